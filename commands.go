@@ -0,0 +1,395 @@
+package main
+
+import (
+	"./proxy"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Commands for running the proxy. They are meant for the console, but players
+// with the permission can use them too. end is called to stop the proxy.
+func registerAdminCommands(p *proxy.Proxy, end func(reason string)) {
+	for _, cmd := range []*proxy.Command{
+		{
+			Name:        "list",
+			Usage:       "list",
+			Description: "Lists the players on each server.",
+			Permission:  "proxy.command.list",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				list(p, sender)
+				return true
+			},
+		},
+		{
+			Name:        "find",
+			Usage:       "find <player>",
+			Description: "Shows which server a player is on.",
+			Permission:  "proxy.command.find",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) != 1 {
+					return false
+				}
+				session := p.Registry.FindByUsername(args[0])
+				if session == nil {
+					sender.SendMessage(fmt.Sprintf("%s isn't online.", args[0]))
+				} else if server := session.CurrentServer(); server == nil {
+					sender.SendMessage(fmt.Sprintf("%s is still connecting.", session.Username()))
+				} else {
+					sender.SendMessage(fmt.Sprintf("%s is on %s.", session.Username(), server.Name))
+				}
+				return true
+			},
+		},
+		{
+			Name:        "kick",
+			Usage:       "kick <player> [reason]",
+			Description: "Disconnects a player from the proxy.",
+			Permission:  "proxy.command.kick",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) < 1 {
+					return false
+				}
+				session := p.Registry.FindByUsername(args[0])
+				if session == nil {
+					sender.SendMessage(fmt.Sprintf("%s isn't online.", args[0]))
+					return true
+				}
+				reason := strings.Join(args[1:], " ")
+				if reason == "" {
+					reason = "Kicked by " + sender.Name()
+				}
+				session.AbandonWithReason(reason)
+				sender.SendMessage(fmt.Sprintf("Kicked %s: %s", session.Username(), reason))
+				return true
+			},
+		},
+		{
+			Name:        "send",
+			Usage:       "send <player|all|server> <target>",
+			Description: "Moves a player, everyone, or everyone on a server to another server.",
+			Permission:  "proxy.command.send",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) != 2 {
+					return false
+				}
+				send(p, sender, args[0], args[1])
+				return true
+			},
+		},
+		{
+			Name:        "alert",
+			Usage:       "alert <message>",
+			Description: "Sends a message to every player.",
+			Permission:  "proxy.command.alert",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) == 0 {
+					return false
+				}
+				sent := p.Broadcast("[Alert] " + strings.Join(args, " "))
+				sender.SendMessage(fmt.Sprintf("Sent to %d players.", sent))
+				return true
+			},
+		},
+		{
+			Name:        "servers",
+			Usage:       "servers",
+			Description: "Shows every server, whether it is up, and who is on it.",
+			Permission:  "proxy.command.servers",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				servers(p, sender)
+				return true
+			},
+		},
+		{
+			Name:        "end",
+			Aliases:     []string{"stop"},
+			Usage:       "end [message]",
+			Description: "Disconnects everyone and stops the proxy.",
+			Permission:  "proxy.command.end",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				reason := strings.Join(args, " ")
+				if reason == "" {
+					reason = "The proxy is shutting down."
+				}
+				sender.SendMessage("Stopping the proxy...")
+				end(reason)
+				return true
+			},
+		},
+		{
+			Name:        "reload",
+			Usage:       "reload",
+			Description: "Reloads the configuration, bans and whitelist.",
+			Permission:  "proxy.command.reload",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if err := p.Reload(); err != nil {
+					sender.SendMessage(fmt.Sprintf("Unable to reload configuration: %s", err.Error()))
+				} else {
+					sender.SendMessage("Reloaded the configuration.")
+				}
+				return true
+			},
+		},
+		{
+			Name:        "ban",
+			Usage:       "ban <player|uuid|ip|cidr> [reason]",
+			Description: "Bans a player, UUID, address or range for good.",
+			Permission:  "proxy.command.ban",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) < 1 {
+					return false
+				}
+				ban(p, sender, args[0], 0, strings.Join(args[1:], " "))
+				return true
+			},
+		},
+		{
+			Name:        "tempban",
+			Usage:       "tempban <player|uuid|ip|cidr> <duration> [reason]",
+			Description: "Bans a player, UUID, address or range for a while.",
+			Permission:  "proxy.command.ban",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) < 2 {
+					return false
+				}
+				duration, err := time.ParseDuration(args[1])
+				if err != nil || duration <= 0 {
+					sender.SendMessage(fmt.Sprintf("Invalid duration %q, use something like 30m or 12h.", args[1]))
+					return true
+				}
+				ban(p, sender, args[0], duration, strings.Join(args[2:], " "))
+				return true
+			},
+		},
+		{
+			Name:        "unban",
+			Usage:       "unban <player|uuid|ip|cidr>",
+			Description: "Lifts a ban.",
+			Permission:  "proxy.command.ban",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				if len(args) != 1 {
+					return false
+				}
+				removed, err := p.Bans.Remove(args[0])
+				if err != nil {
+					sender.SendMessage(fmt.Sprintf("Unable to save bans: %s", err.Error()))
+				} else if removed {
+					sender.SendMessage(fmt.Sprintf("Unbanned %s.", args[0]))
+				} else {
+					sender.SendMessage(fmt.Sprintf("%s isn't banned.", args[0]))
+				}
+				return true
+			},
+		},
+		{
+			Name:        "bans",
+			Usage:       "bans",
+			Description: "Lists all bans.",
+			Permission:  "proxy.command.ban",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				bans := p.Bans.All()
+				if len(bans) == 0 {
+					sender.SendMessage("Nobody is banned.")
+				}
+				for _, b := range bans {
+					expires := "never"
+					if b.Expires != nil {
+						expires = b.Expires.Format(time.RFC1123)
+					}
+					sender.SendMessage(fmt.Sprintf("%s %s by %s, expires %s: %s", b.Type, b.Target, b.Issuer, expires, b.Reason))
+				}
+				return true
+			},
+		},
+		{
+			Name:        "throttle",
+			Usage:       "throttle",
+			Description: "Shows how many sessions were let in and refused.",
+			Permission:  "proxy.command.throttle",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				stats := p.Throttle.Stats()
+				sender.SendMessage(fmt.Sprintf("Accepted %d sessions, refused %d for joining too often and %d for having too many sessions.",
+					stats.Accepted, stats.Throttled, stats.Limited))
+				if p.Config().Throttle.HandshakeCookie {
+					sender.SendMessage(fmt.Sprintf("Dropped %d handshakes with a bad cookie.", p.Cookies.Rejected()))
+				}
+				return true
+			},
+		},
+		{
+			Name:        "whitelist",
+			Usage:       "whitelist <on|off|list|add <player|uuid>|remove <player|uuid>>",
+			Description: "Manages the whitelist.",
+			Permission:  "proxy.command.whitelist",
+			Handler: func(sender proxy.CommandSender, args []string) bool {
+				return whitelist(p, sender, args)
+			},
+		},
+	} {
+		if err := p.Commands.Register(cmd); err != nil {
+			log.Printf("Unable to register %s: %s", cmd.Name, err.Error())
+		}
+	}
+}
+
+// Returns the players on each server, sorted by name. Players who are still
+// connecting are under "".
+func playersByServer(p *proxy.Proxy) map[string][]string {
+	players := make(map[string][]string)
+	for _, session := range p.Registry.Players() {
+		name := ""
+		if server := session.CurrentServer(); server != nil {
+			name = server.Name
+		}
+		players[name] = append(players[name], session.Username())
+	}
+	for _, names := range players {
+		sort.Strings(names)
+	}
+	return players
+}
+
+func list(p *proxy.Proxy, sender proxy.CommandSender) {
+	players := playersByServer(p)
+	var names []string
+	for name := range players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := 0
+	for _, name := range names {
+		label := name
+		if name == "" {
+			label = "Connecting"
+		}
+		sender.SendMessage(fmt.Sprintf("[%s] (%d): %s", label, len(players[name]), strings.Join(players[name], ", ")))
+		total += len(players[name])
+	}
+	sender.SendMessage(fmt.Sprintf("%d players are online.", total))
+}
+
+func servers(p *proxy.Proxy, sender proxy.CommandSender) {
+	players := playersByServer(p)
+	all := p.Servers()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	for _, server := range all {
+		status := server.Status()
+		state := "down"
+		if status.Online {
+			state = fmt.Sprintf("up, %s", status.Latency)
+		}
+		sender.SendMessage(fmt.Sprintf("%s (%s, %s): %d players through the proxy", server.Name, server.Address, state,
+			len(players[server.Name])))
+	}
+}
+
+// Moves a player, everyone ("all"), or everyone on a server to target.
+func send(p *proxy.Proxy, sender proxy.CommandSender, who string, target string) {
+	var sessions []*proxy.Session
+	if strings.EqualFold(who, "all") {
+		sessions = p.Registry.Players()
+	} else if session := p.Registry.FindByUsername(who); session != nil {
+		sessions = append(sessions, session)
+	} else if p.GetServer(who) != nil {
+		for _, session := range p.Registry.Players() {
+			if server := session.CurrentServer(); server != nil && server.Name == who {
+				sessions = append(sessions, session)
+			}
+		}
+	} else {
+		sender.SendMessage(fmt.Sprintf("There is no player or server called %s.", who))
+		return
+	}
+	if p.GetServer(target) == nil && p.GetGroup(target) == nil {
+		sender.SendMessage(fmt.Sprintf("There is no server called %s.", target))
+		return
+	}
+
+	sent := 0
+	for _, session := range sessions {
+		if server := session.CurrentServer(); server != nil && server.Name == target {
+			continue
+		}
+		sent++
+		session := session
+		go func() {
+			if err := session.ConnectTo(target); err != nil {
+				session.SendMessage(err.Error())
+				sender.SendMessage(fmt.Sprintf("Unable to send %s to %s: %s", session.Username(), target, err.Error()))
+			}
+		}()
+	}
+	sender.SendMessage(fmt.Sprintf("Sending %d players to %s.", sent, target))
+}
+
+func reload(p *proxy.Proxy) {
+	if err := p.Reload(); err != nil {
+		log.Printf("Unable to reload configuration: %s", err.Error())
+	}
+}
+
+func ban(p *proxy.Proxy, sender proxy.CommandSender, target string, duration time.Duration, reason string) {
+	b := proxy.NewBan(target, reason, sender.Name(), duration)
+	kicked, err := p.Ban(b)
+	if err != nil {
+		sender.SendMessage(fmt.Sprintf("Unable to save bans: %s", err.Error()))
+		return
+	}
+	sender.SendMessage(fmt.Sprintf("Banned %s %s, disconnecting %d players.", b.Type, b.Target, kicked))
+}
+
+func whitelist(p *proxy.Proxy, sender proxy.CommandSender, args []string) bool {
+	if len(args) < 1 {
+		return false
+	}
+	switch mode := strings.ToLower(args[0]); mode {
+	case "on", "off":
+		if err := p.Whitelist.SetEnabled(mode == "on"); err != nil {
+			sender.SendMessage(fmt.Sprintf("Unable to save the whitelist: %s", err.Error()))
+			return true
+		}
+		sender.SendMessage(fmt.Sprintf("The whitelist is now %s, disconnecting %d players.", mode, p.EnforceWhitelist()))
+	case "list":
+		names, uuids := p.Whitelist.List()
+		state := "off"
+		if p.Whitelist.IsEnabled() {
+			state = "on"
+		}
+		sender.SendMessage(fmt.Sprintf("The whitelist is %s.", state))
+		sender.SendMessage("Players: " + strings.Join(names, ", "))
+		sender.SendMessage("UUIDs: " + strings.Join(uuids, ", "))
+	case "add":
+		if len(args) != 2 {
+			return false
+		}
+		added, err := p.Whitelist.Add(args[1])
+		if err != nil {
+			sender.SendMessage(fmt.Sprintf("Unable to save the whitelist: %s", err.Error()))
+		} else if added {
+			sender.SendMessage(fmt.Sprintf("Added %s to the whitelist.", args[1]))
+		} else {
+			sender.SendMessage(fmt.Sprintf("%s is already whitelisted.", args[1]))
+		}
+	case "remove":
+		if len(args) != 2 {
+			return false
+		}
+		removed, err := p.Whitelist.Remove(args[1])
+		if err != nil {
+			sender.SendMessage(fmt.Sprintf("Unable to save the whitelist: %s", err.Error()))
+		} else if removed {
+			sender.SendMessage(fmt.Sprintf("Removed %s from the whitelist, disconnecting %d players.", args[1], p.EnforceWhitelist()))
+		} else {
+			sender.SendMessage(fmt.Sprintf("%s isn't whitelisted.", args[1]))
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"./proxy"
+	"fmt"
+	"github.com/chzyer/readline"
+	"log"
+	"strings"
+)
+
+// The console may run every command. Replies are printed.
+type consoleSender struct{}
+
+func (consoleSender) Name() string {
+	return "CONSOLE"
+}
+
+func (consoleSender) SendMessage(msg string) error {
+	fmt.Println(msg)
+	return nil
+}
+
+func (consoleSender) HasPermission(node string) bool {
+	return true
+}
+
+// Completes command names, and player and server names after them.
+type consoleCompleter struct {
+	p *proxy.Proxy
+}
+
+func (this consoleCompleter) Do(line []rune, pos int) (suggestions [][]rune, length int) {
+	typed := string(line[:pos])
+	word := typed[strings.LastIndex(typed, " ")+1:]
+
+	var candidates []string
+	if !strings.Contains(typed, " ") {
+		candidates = this.p.Commands.Complete(consoleSender{}, word)
+	} else {
+		for _, session := range this.p.Registry.Players() {
+			candidates = append(candidates, session.Username())
+		}
+		for _, server := range this.p.Servers() {
+			candidates = append(candidates, server.Name)
+		}
+	}
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(word)) {
+			suggestions = append(suggestions, []rune(candidate[len(word):]+" "))
+		}
+	}
+	return suggestions, len([]rune(word))
+}
+
+// Reads commands from the terminal until the proxy is stopped, with line
+// editing, history and tab completion.
+func runConsole(p *proxy.Proxy, historyFile string, stopped <-chan struct{}) error {
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "> ",
+		HistoryFile:     historyFile,
+		AutoComplete:    consoleCompleter{p},
+		InterruptPrompt: "^C",
+		EOFPrompt:       "end",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	// Keep log lines from messing up what is being typed.
+	log.SetOutput(rl.Stderr())
+
+	go func() {
+		<-stopped
+		rl.Close()
+	}()
+
+	fmt.Println("Type help for a list of commands, and end to stop the proxy.")
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			if line == "" {
+				fmt.Println("Type end to stop the proxy.")
+			}
+			continue
+		}
+		if err != nil {
+			// The proxy was stopped, or the console was closed (Ctrl-D).
+			return nil
+		}
+		runConsoleCommand(p, line)
+	}
+}
+
+// Runs a line typed into the console.
+func runConsoleCommand(p *proxy.Proxy, line string) {
+	if err := p.Commands.Dispatch(consoleSender{}, line); err == proxy.ErrUnknownCommand {
+		if args := strings.Fields(line); len(args) > 0 {
+			fmt.Printf("Unknown command %q, try help.\n", args[0])
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package forwarding encodes the information the proxy passes on to backend
+// servers about the player behind a connection, which the backends would
+// otherwise never see.
+//
+// The data replaces the ServerAddress field of the login packet sent to the
+// backend. It is made up of fields separated by NUL characters:
+//
+//	<host>\x00<client ip>:<client port>\x00<uuid>
+//
+// host is the server address the client typed in, so backends can still tell
+// which address a player used. Backend plugins only have to split the field on
+// NUL characters to get the player's real address.
+//
+// If the proxy has a forwarding secret, the data is signed and five more
+// fields follow:
+//
+//	...\x00<username>\x00<server>\x00<unix timestamp>\x00<nonce>\x00<signature>
+//
+// server is the name of the server in the proxy's config the data was sent
+// to, so it can't be replayed against another server.
+//
+// The signature is the hex encoded HMAC-SHA256, keyed with the secret, of
+// everything before it (including the last NUL). Use a Verifier to check it.
+package forwarding
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/pborman/uuid"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const separator = "\x00"
+
+type Data struct {
+	// The server address the client typed in.
+	Host string
+	// The client's real address.
+	Address *net.UDPAddr
+	// The UUID the client logged in with.
+	UUID uuid.UUID
+
+	// Only set for signed data.
+	Username string
+	// The name of the server the data is for.
+	Server    string
+	Timestamp time.Time
+	Nonce     string
+
+	// INTERNAL: what the signature covers, and the signature itself
+	signed    string
+	signature string
+}
+
+// Returns the value to put in the login packet's ServerAddress field.
+func (this *Data) Encode() string {
+	// The host comes from the client, which could try to slip in fields of
+	// its own.
+	host := this.Host
+	if i := strings.Index(host, separator); i >= 0 {
+		host = host[:i]
+	}
+	return strings.Join([]string{host, this.Address.String(), this.UUID.String()}, separator)
+}
+
+// Like Encode, but signs the data with a secret shared with the backends. The
+// timestamp and nonce are filled in.
+func (this *Data) Sign(secret []byte) string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	this.Timestamp = time.Now()
+	this.Nonce = hex.EncodeToString(nonce)
+
+	signed := strings.Join([]string{
+		this.Encode(),
+		this.Username,
+		this.Server,
+		strconv.FormatInt(this.Timestamp.Unix(), 10),
+		this.Nonce,
+	}, separator) + separator
+	return signed + signature(secret, signed)
+}
+
+func signature(secret []byte, signed string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Whether the data came with a signature. Parse doesn't check it; use a
+// Verifier for that.
+func (this *Data) IsSigned() bool {
+	return this.signature != ""
+}
+
+// Reads forwarding data from a login packet's ServerAddress field. Fails if
+// the field doesn't hold forwarding data, which usually means the player
+// didn't connect through the proxy.
+func Parse(serverAddress string) (*Data, error) {
+	fields := strings.Split(serverAddress, separator)
+	if len(fields) != 3 && len(fields) != 8 {
+		return nil, errors.New("No forwarding data.")
+	}
+
+	host, port, err := net.SplitHostPort(fields[1])
+	if err != nil {
+		return nil, errors.New("Invalid forwarded address.")
+	}
+	ip := net.ParseIP(host)
+	portNum, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil, errors.New("Invalid forwarded address.")
+	}
+
+	id := uuid.Parse(fields[2])
+	if id == nil {
+		return nil, errors.New("Invalid forwarded UUID.")
+	}
+
+	data := &Data{
+		Host:    fields[0],
+		Address: &net.UDPAddr{IP: ip, Port: portNum},
+		UUID:    id,
+	}
+	if len(fields) == 8 {
+		timestamp, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid forwarded timestamp.")
+		}
+		data.Username = fields[3]
+		data.Server = fields[4]
+		data.Timestamp = time.Unix(timestamp, 0)
+		data.Nonce = fields[6]
+		data.signature = fields[7]
+		data.signed = serverAddress[:len(serverAddress)-len(data.signature)]
+	}
+	return data, nil
+}
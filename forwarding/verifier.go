@@ -0,0 +1,90 @@
+package forwarding
+
+import (
+	"crypto/hmac"
+	"errors"
+	"github.com/pborman/uuid"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsigned     = errors.New("The forwarding data is not signed.")
+	ErrBadSignature = errors.New("The forwarding data has an invalid signature.")
+	ErrMismatch     = errors.New("The forwarding data is for another player.")
+	ErrWrongServer  = errors.New("The forwarding data is for another server.")
+	ErrExpired      = errors.New("The forwarding data has expired.")
+	ErrReplayed     = errors.New("The forwarding data has been used before.")
+)
+
+// Checks signed forwarding data on the backend side. Logins whose data isn't
+// signed with the shared secret, is for another player or server, is too old,
+// or has been seen before are rejected.
+//
+//	verifier := forwarding.NewVerifier([]byte(secret), "lobby", 30*time.Second)
+//	data, err := verifier.Verify(login.ServerAddress, login.Username, login.ClientUuid)
+//	if err != nil {
+//		// Disconnect the player.
+//	}
+type Verifier struct {
+	sync.Mutex
+	secret []byte
+	server string
+	maxAge time.Duration
+	// INTERNAL: nonces we've accepted, and when they expire
+	seen map[string]time.Time
+}
+
+// server is the name the proxy knows this server by. maxAge is how far the
+// timestamp may be from our clock, so it should allow for the clocks of the
+// proxy and the backend not quite agreeing.
+func NewVerifier(secret []byte, server string, maxAge time.Duration) (this *Verifier) {
+	this = new(Verifier)
+	this.secret = secret
+	this.server = server
+	this.maxAge = maxAge
+	this.seen = make(map[string]time.Time)
+	return
+}
+
+// Checks the ServerAddress field of a login packet, along with the username
+// and UUID from the same packet. Returns the forwarding data if it can be
+// trusted.
+func (this *Verifier) Verify(serverAddress string, username string, id uuid.UUID) (*Data, error) {
+	data, err := Parse(serverAddress)
+	if err != nil {
+		return nil, err
+	}
+	if !data.IsSigned() {
+		return nil, ErrUnsigned
+	}
+	if !hmac.Equal([]byte(data.signature), []byte(signature(this.secret, data.signed))) {
+		return nil, ErrBadSignature
+	}
+	if data.Username != username || !uuid.Equal(data.UUID, id) {
+		return nil, ErrMismatch
+	}
+	if data.Server != this.server {
+		return nil, ErrWrongServer
+	}
+
+	now := time.Now()
+	age := now.Sub(data.Timestamp)
+	if age > this.maxAge || age < -this.maxAge {
+		return nil, ErrExpired
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	for nonce, expires := range this.seen {
+		if now.After(expires) {
+			delete(this.seen, nonce)
+		}
+	}
+	if _, ok := this.seen[data.Nonce]; ok {
+		return nil, ErrReplayed
+	}
+	// Anything older than this is rejected as expired anyway.
+	this.seen[data.Nonce] = data.Timestamp.Add(this.maxAge + time.Second)
+	return data, nil
+}
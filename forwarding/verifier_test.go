@@ -0,0 +1,111 @@
+package forwarding
+
+import (
+	"github.com/pborman/uuid"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secret   = []byte("correct horse battery staple")
+	playerId = uuid.Parse("12345678-9abc-def0-1234-56789abcdef0")
+)
+
+func newData() *Data {
+	return &Data{
+		Host:     "play.example.net:19132",
+		Address:  &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51234},
+		UUID:     playerId,
+		Username: "Steve",
+		Server:   "lobby",
+	}
+}
+
+func newVerifier() *Verifier {
+	return NewVerifier(secret, "lobby", 30*time.Second)
+}
+
+// Changes some fields of signed data, then signs it again with key, like
+// someone who knows (or is guessing) the secret would.
+func resign(signed string, key []byte, change func(fields []string)) string {
+	fields := strings.Split(signed, separator)
+	change(fields)
+	data := strings.Join(fields[:len(fields)-1], separator) + separator
+	return data + signature(key, data)
+}
+
+func TestVerify(t *testing.T) {
+	data := newData()
+	signed := data.Sign(secret)
+
+	got, err := newVerifier().Verify(signed, "Steve", playerId)
+	if err != nil {
+		t.Fatalf("rejected data straight from Sign: %s", err.Error())
+	}
+	if got.Host != data.Host || got.Address.String() != data.Address.String() || !uuid.Equal(got.UUID, playerId) {
+		t.Errorf("got %s, %s, %s, expected %s, %s, %s", got.Host, got.Address, got.UUID, data.Host, data.Address, playerId)
+	}
+	if got.Username != "Steve" || got.Server != "lobby" || got.Nonce != data.Nonce || got.Timestamp.Unix() != data.Timestamp.Unix() {
+		t.Errorf("got %+v, expected %+v", got, data)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signed := newData().Sign(secret)
+	tests := []struct {
+		name     string
+		data     string
+		username string
+		uuid     uuid.UUID
+		err      error
+	}{
+		{"unsigned", newData().Encode(), "Steve", playerId, ErrUnsigned},
+		{"wrong secret", resign(signed, []byte("hunter2"), func(fields []string) {}), "Steve", playerId, ErrBadSignature},
+		{"changed address", strings.Replace(signed, "203.0.113.7", "198.51.100.1", 1), "Steve", playerId, ErrBadSignature},
+		{"changed server", strings.Replace(signed, separator+"lobby"+separator, separator+"games"+separator, 1), "Steve", playerId, ErrBadSignature},
+		{"other username", signed, "Alex", playerId, ErrMismatch},
+		{"other uuid", signed, "Steve", uuid.NewRandom(), ErrMismatch},
+		{"other server", resign(signed, secret, func(fields []string) { fields[4] = "games" }), "Steve", playerId, ErrWrongServer},
+		{"too old", resign(signed, secret, func(fields []string) {
+			fields[5] = strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+		}), "Steve", playerId, ErrExpired},
+		{"from the future", resign(signed, secret, func(fields []string) {
+			fields[5] = strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+		}), "Steve", playerId, ErrExpired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newVerifier().Verify(test.data, test.username, test.uuid); err != test.err {
+				t.Errorf("got %v, expected %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	verifier := newVerifier()
+	signed := newData().Sign(secret)
+
+	if _, err := verifier.Verify(signed, "Steve", playerId); err != nil {
+		t.Fatalf("rejected the first login: %s", err.Error())
+	}
+	if _, err := verifier.Verify(signed, "Steve", playerId); err != ErrReplayed {
+		t.Errorf("got %v for the same data again, expected %v", err, ErrReplayed)
+	}
+	// A fresh nonce is fine.
+	if _, err := verifier.Verify(newData().Sign(secret), "Steve", playerId); err != nil {
+		t.Errorf("rejected a second login: %s", err.Error())
+	}
+}
+
+func TestParseUnforwarded(t *testing.T) {
+	for _, address := range []string{"play.example.net:19132", "a\x00b", "a\x00not an address\x00" + playerId.String()} {
+		if _, err := Parse(address); err == nil {
+			t.Errorf("parsed %q", address)
+		}
+	}
+}
@@ -2,19 +2,21 @@ package main
 
 import (
 	"./proxy"
-	"bufio"
 	"flag"
-	"fmt"
 	"log"
 	"math/rand"
-	"net"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"sync"
+	"syscall"
 	"time"
 )
 
+var configPath = flag.String("config", "config.toml", "path to the proxy configuration file")
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+var historyFile = flag.String("history", ".console_history", "file to keep console history in, empty to not keep any")
 
 func main() {
 	// Initialize the global random state with something not phony.
@@ -31,21 +33,50 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	// Load the configuration.
+	config, err := proxy.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Unable to load %s: %s", *configPath, err.Error())
+	}
+
 	// Start the proxy.
-	a, _ := net.ResolveUDPAddr("udp4", ":19132")
-	p := proxy.NewProxy(a)
+	p, err := proxy.NewProxy(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 	go p.ListenAndServe()
 
-	fmt.Println("'.' stops the proxy.")
+	// Stopping disconnects everyone first. It can be asked for from the
+	// console, by a player, or with a signal.
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	end := func(reason string) {
+		stopOnce.Do(func() {
+			log.Println("Stopping the proxy...")
+			p.Shutdown(reason)
+			close(stopped)
+		})
+	}
+	registerAdminCommands(p, end)
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "." {
-			p.Close()
-			break
+	// SIGHUP reloads the configuration, SIGTERM stops the proxy.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				reload(p)
+			} else {
+				end("The proxy is shutting down.")
+			}
 		}
+	}()
+
+	if err := runConsole(p, *historyFile, stopped); err != nil {
+		log.Printf("Unable to start the console: %s", err.Error())
+		<-stopped
 	}
+	end("The proxy is shutting down.")
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -0,0 +1,366 @@
+// Package metrics keeps counters and histograms and writes them out in the
+// Prometheus text format. It only does what the proxy needs, so it doesn't
+// pull in the whole Prometheus client.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Something that can write one or more metric families.
+type Collector interface {
+	WriteText(w io.Writer) error
+}
+
+type Registry struct {
+	sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() (this *Registry) {
+	this = new(Registry)
+	return
+}
+
+// Where packages register the metrics they keep for the whole process.
+var Default = NewRegistry()
+
+func (this *Registry) Register(c Collector) {
+	this.Lock()
+	this.collectors = append(this.collectors, c)
+	this.Unlock()
+}
+
+// Writes every registered metric, in the order they were registered.
+func (this *Registry) WriteText(w io.Writer) error {
+	this.Lock()
+	collectors := append([]Collector(nil), this.collectors...)
+	this.Unlock()
+
+	for _, c := range collectors {
+		if err := c.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Content type of the Prometheus text format.
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Serves the given collectors as a Prometheus scrape target.
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		buf := bufio.NewWriter(w)
+		for _, c := range collectors {
+			if err := c.WriteText(buf); err != nil {
+				return
+			}
+		}
+		buf.Flush()
+	})
+}
+
+// What all metric families have in common.
+type family struct {
+	name   string
+	help   string
+	labels []string
+
+	// INTERNAL: children by label values, joined with labelSeparator
+	lock     sync.Mutex
+	children map[string]interface{}
+}
+
+// Can't show up in label values that are worth keeping apart.
+const labelSeparator = "\xff"
+
+func (this *family) init(name string, help string, labels []string) {
+	this.name = name
+	this.help = help
+	this.labels = labels
+	this.children = make(map[string]interface{})
+}
+
+// Returns the child for some label values, creating it with create if there
+// isn't one yet.
+func (this *family) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(this.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", this.name, len(this.labels), len(values)))
+	}
+	key := strings.Join(values, labelSeparator)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	c, ok := this.children[key]
+	if !ok {
+		c = create()
+		this.children[key] = c
+	}
+	return c
+}
+
+// Returns the children sorted by label values, with their rendered labels.
+func (this *family) sorted() (labels []string, children []interface{}) {
+	this.lock.Lock()
+	keys := make([]string, 0, len(this.children))
+	for key := range this.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		children = append(children, this.children[key])
+	}
+	this.lock.Unlock()
+
+	for _, key := range keys {
+		var values []string
+		if len(this.labels) > 0 {
+			values = strings.Split(key, labelSeparator)
+		}
+		labels = append(labels, formatLabels(this.labels, values))
+	}
+	return
+}
+
+func (this *family) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", this.name, escapeHelp(this.help), this.name, kind)
+	return err
+}
+
+// Renders labels as {a="1",b="2"}, or nothing if there are none.
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + quoteLabel(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Adds a label to already rendered ones.
+func addLabel(labels string, name string, value string) string {
+	pair := name + "=" + quoteLabel(value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// A value that only goes up.
+type Counter struct {
+	val uint64
+}
+
+func (this *Counter) Inc() {
+	atomic.AddUint64(&this.val, 1)
+}
+
+func (this *Counter) Add(n uint64) {
+	atomic.AddUint64(&this.val, n)
+}
+
+func (this *Counter) Value() uint64 {
+	return atomic.LoadUint64(&this.val)
+}
+
+// Counters told apart by labels.
+type CounterVec struct {
+	family
+}
+
+// Creates a counter and registers it with the default registry.
+func NewCounter(name string, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// Creates a family of counters and registers it with the default registry.
+func NewCounterVec(name string, help string, labels ...string) (this *CounterVec) {
+	this = new(CounterVec)
+	this.family.init(name, help, labels)
+	Default.Register(this)
+	return
+}
+
+// Returns the counter for some label values, in the order the labels were
+// given when creating the family.
+func (this *CounterVec) With(values ...string) *Counter {
+	return this.child(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (this *CounterVec) WriteText(w io.Writer) error {
+	if err := this.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	labels, children := this.sorted()
+	for i, c := range children {
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", this.name, labels[i], c.(*Counter).Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counts observations into buckets, like how long something took.
+type Histogram struct {
+	sync.Mutex
+	// Upper bounds, sorted. There is always an implicit +Inf bucket too.
+	bounds []float64
+	// INTERNAL: per bucket, not cumulative
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (this *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(this.bounds, v)
+	this.Lock()
+	this.counts[i]++
+	this.count++
+	this.sum += v
+	this.Unlock()
+}
+
+// Histograms told apart by labels, all with the same buckets.
+type HistogramVec struct {
+	family
+	bounds []float64
+}
+
+// Creates a histogram and registers it with the default registry.
+func NewHistogram(name string, help string, bounds []float64) *Histogram {
+	return NewHistogramVec(name, help, bounds).With()
+}
+
+// Creates a family of histograms and registers it with the default registry.
+// bounds are the buckets' upper bounds, in increasing order.
+func NewHistogramVec(name string, help string, bounds []float64, labels ...string) (this *HistogramVec) {
+	this = new(HistogramVec)
+	this.family.init(name, help, labels)
+	this.bounds = bounds
+	Default.Register(this)
+	return
+}
+
+func (this *HistogramVec) With(values ...string) *Histogram {
+	return this.child(values, func() interface{} {
+		return &Histogram{
+			bounds: this.bounds,
+			counts: make([]uint64, len(this.bounds)+1),
+		}
+	}).(*Histogram)
+}
+
+func (this *HistogramVec) WriteText(w io.Writer) error {
+	if err := this.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	labels, children := this.sorted()
+	for i, c := range children {
+		h := c.(*Histogram)
+		h.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.Unlock()
+
+		var cumulative uint64
+		for j, n := range counts {
+			cumulative += n
+			le := math.Inf(1)
+			if j < len(h.bounds) {
+				le = h.bounds[j]
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", this.name, addLabel(labels[i], "le", formatFloat(le)), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", this.name, labels[i], formatFloat(sum), this.name, labels[i], count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bounds growing by factor, from start, e.g. ExponentialBuckets(1, 2, 4) is
+// 1, 2, 4 and 8.
+func ExponentialBuckets(start float64, factor float64, count int) []float64 {
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+	return bounds
+}
+
+// A gauge with one label, read when scraped. Good for things that are already
+// kept elsewhere, like how many players are on each server.
+type GaugeFunc struct {
+	name  string
+	help  string
+	label string
+	// Returns the value for each label value.
+	values func() map[string]float64
+}
+
+// Unlike the other metrics, these aren't registered with the default
+// registry, since they usually read from something that isn't global.
+func NewGaugeFunc(name string, help string, label string, values func() map[string]float64) (this *GaugeFunc) {
+	this = new(GaugeFunc)
+	this.name = name
+	this.help = help
+	this.label = label
+	this.values = values
+	return
+}
+
+func (this *GaugeFunc) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", this.name, escapeHelp(this.help), this.name); err != nil {
+		return err
+	}
+	values := this.values()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", this.name, formatLabels([]string{this.label}, []string{key}), formatFloat(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
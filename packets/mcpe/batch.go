@@ -28,8 +28,8 @@ func (pkt *MCPEBatch) Id() byte {
 }
 
 func (pkt *MCPEBatch) Decode(reader io.Reader) (err error) {
-	// FIXME: Do we need to bother?
-	_, err = raknet.ReadInt32(reader)
+	// The compressed length. Only used for the compression ratio.
+	compressed, err := raknet.ReadInt32(reader)
 
 	r, err := zlib.NewReader(reader)
 	if err != nil {
@@ -38,6 +38,7 @@ func (pkt *MCPEBatch) Decode(reader io.Reader) (err error) {
 	defer r.Close()
 
 	var pkts [][]byte
+	uncompressed := 0
 	for {
 		ln, err := raknet.ReadInt32(r)
 		if err != nil {
@@ -49,14 +50,16 @@ func (pkt *MCPEBatch) Decode(reader io.Reader) (err error) {
 		}
 
 		data := make([]byte, ln)
-		_, err = r.Read(data)
+		_, err = io.ReadFull(r, data)
 		if err != nil {
 			return err
 		}
 
 		pkts = append(pkts, data)
+		uncompressed += 4 + len(data)
 	}
 
+	observeCompression("decode", uncompressed, int(compressed))
 	pkt.Payload = pkts
 	return
 }
@@ -71,7 +74,9 @@ func (pkt MCPEBatch) Encode(writer io.Writer) (err error) {
 	buffer := new(bytes.Buffer)
 	w := zlib.NewWriter(buffer)
 
+	uncompressed := 0
 	for _, payload := range pkt.Payload {
+		uncompressed += 4 + len(payload)
 		if err = raknet.WriteInt32(w, int32(len(payload))); err != nil {
 			return err
 		}
@@ -85,6 +90,7 @@ func (pkt MCPEBatch) Encode(writer io.Writer) (err error) {
 		return
 	}
 
+	observeCompression("encode", uncompressed, buffer.Len())
 	err = raknet.WriteInt32(writer, int32(buffer.Len()))
 	_, err = io.Copy(writer, buffer)
 	return
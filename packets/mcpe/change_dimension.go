@@ -0,0 +1,53 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+type MCPEChangeDimension struct {
+	Dimension byte
+	Location  PlayerLocation
+	Unknown   byte
+}
+
+func (*MCPEChangeDimension) Id() byte {
+	return ID_MCPE_CHANGE_DIMENSION
+}
+
+func (pkt *MCPEChangeDimension) Decode(reader io.Reader) (err error) {
+	dim, err := raknet.ReadByte(reader)
+	if err != nil {
+		return
+	}
+	l, err := NewPlayerLocation(reader)
+	if err != nil {
+		return
+	}
+	u, err := raknet.ReadByte(reader)
+	if err != nil {
+		return
+	}
+
+	pkt.Dimension = dim
+	pkt.Location = l
+	pkt.Unknown = u
+	return
+}
+
+func (pkt MCPEChangeDimension) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_CHANGE_DIMENSION)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteByte(writer, pkt.Dimension)
+	if err != nil {
+		return
+	}
+	err = pkt.Location.Write(writer)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteByte(writer, pkt.Unknown)
+	return
+}
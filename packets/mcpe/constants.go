@@ -1,13 +1,39 @@
 package mcpe
 
+// Packet IDs for MCPE 0.13 (protocol 38).
 const (
-	ID_MCPE_LOGIN         byte = 0x8f
-	ID_MCPE_PLAYER_STATUS byte = 0x90
-	ID_MCPE_DISCONNECT    byte = 0x91
-	ID_MCPE_BATCH         byte = 0x92
-	ID_MCPE_TEXT          byte = 0x93
-	ID_MCPE_START_GAME    byte = 0x95
-	ID_MCPE_ANIMATE       byte = 0xb3
-	ID_MCPE_RESPAWN       byte = 0xb3
-	ID_MCPE_PLAYER_LIST   byte = 0xc3
+	ID_MCPE_LOGIN               byte = 0x8f
+	ID_MCPE_PLAYER_STATUS       byte = 0x90
+	ID_MCPE_DISCONNECT          byte = 0x91
+	ID_MCPE_BATCH               byte = 0x92
+	ID_MCPE_TEXT                byte = 0x93
+	ID_MCPE_SET_TIME            byte = 0x94
+	ID_MCPE_START_GAME          byte = 0x95
+	ID_MCPE_ADD_PLAYER          byte = 0x96
+	ID_MCPE_REMOVE_PLAYER       byte = 0x97
+	ID_MCPE_ADD_ENTITY          byte = 0x98
+	ID_MCPE_REMOVE_ENTITY       byte = 0x99
+	ID_MCPE_ADD_ITEM_ENTITY     byte = 0x9a
+	ID_MCPE_TAKE_ITEM_ENTITY    byte = 0x9b
+	ID_MCPE_MOVE_ENTITY         byte = 0x9c
+	ID_MCPE_MOVE_PLAYER         byte = 0x9d
+	ID_MCPE_ADD_PAINTING        byte = 0xa0
+	ID_MCPE_ENTITY_EVENT        byte = 0xa4
+	ID_MCPE_MOB_EFFECT          byte = 0xa5
+	ID_MCPE_UPDATE_ATTRIBUTES   byte = 0xa6
+	ID_MCPE_MOB_EQUIPMENT       byte = 0xa7
+	ID_MCPE_MOB_ARMOR_EQUIPMENT byte = 0xa8
+	ID_MCPE_INTERACT            byte = 0xa9
+	ID_MCPE_PLAYER_ACTION       byte = 0xab
+	ID_MCPE_SET_ENTITY_DATA     byte = 0xad
+	ID_MCPE_SET_ENTITY_MOTION   byte = 0xae
+	ID_MCPE_SET_ENTITY_LINK     byte = 0xaf
+	ID_MCPE_SET_SPAWN_POSITION  byte = 0xb1
+	ID_MCPE_ANIMATE             byte = 0xb2
+	ID_MCPE_RESPAWN             byte = 0xb3
+	ID_MCPE_FULL_CHUNK_DATA     byte = 0xbf
+	ID_MCPE_SET_DIFFICULTY      byte = 0xc0
+	ID_MCPE_CHANGE_DIMENSION    byte = 0xc1
+	ID_MCPE_SET_PLAYER_GAMETYPE byte = 0xc2
+	ID_MCPE_PLAYER_LIST         byte = 0xc3
 )
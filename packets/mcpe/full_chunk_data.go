@@ -0,0 +1,87 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+const (
+	CHUNK_ORDER_COLUMNS byte = 0
+	CHUNK_ORDER_LAYERED byte = 1
+)
+
+// Block IDs, block data, sky light, block light, the height map, biome colors
+// and the (little-endian) length of the extra data, with no tiles after it.
+const EMPTY_CHUNK_SIZE = 16*16*128 + 3*16*16*64 + 16*16 + 16*16*4 + 4
+
+type MCPEFullChunkData struct {
+	ChunkX int32
+	ChunkZ int32
+	Order  byte
+	Data   []byte
+}
+
+// Returns a chunk with nothing but air in it, which replaces whatever the
+// client had there before.
+func NewEmptyChunk(x, z int32) MCPEFullChunkData {
+	return MCPEFullChunkData{x, z, CHUNK_ORDER_COLUMNS, make([]byte, EMPTY_CHUNK_SIZE)}
+}
+
+func (*MCPEFullChunkData) Id() byte {
+	return ID_MCPE_FULL_CHUNK_DATA
+}
+
+func (pkt *MCPEFullChunkData) Decode(reader io.Reader) (err error) {
+	x, err := raknet.ReadInt32(reader)
+	if err != nil {
+		return
+	}
+	z, err := raknet.ReadInt32(reader)
+	if err != nil {
+		return
+	}
+	order, err := raknet.ReadByte(reader)
+	if err != nil {
+		return
+	}
+	ln, err := raknet.ReadInt32(reader)
+	if err != nil {
+		return
+	}
+	data := make([]byte, ln)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return
+	}
+
+	pkt.ChunkX = x
+	pkt.ChunkZ = z
+	pkt.Order = order
+	pkt.Data = data
+	return
+}
+
+func (pkt MCPEFullChunkData) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_FULL_CHUNK_DATA)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt32(writer, pkt.ChunkX)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt32(writer, pkt.ChunkZ)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteByte(writer, pkt.Order)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt32(writer, int32(len(pkt.Data)))
+	if err != nil {
+		return
+	}
+	_, err = writer.Write(pkt.Data)
+	return
+}
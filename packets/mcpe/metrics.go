@@ -0,0 +1,14 @@
+package mcpe
+
+import "../../metrics"
+
+var batchCompressionRatio = metrics.NewHistogramVec("mcpe_batch_compression_ratio",
+	"How many times smaller batches are compressed, by whether they were decoded or encoded.",
+	[]float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32}, "op")
+
+// Records how well a batch compressed.
+func observeCompression(op string, uncompressed int, compressed int) {
+	if compressed > 0 {
+		batchCompressionRatio.With(op).Observe(float64(uncompressed) / float64(compressed))
+	}
+}
@@ -84,6 +84,7 @@ func (pkt *MCPEPlayerList) Decode(reader io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	pkt.Action = PlayerListAction(a)
 
 	ln, err := raknet.ReadInt32(reader)
 	if err != nil {
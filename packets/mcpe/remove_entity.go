@@ -0,0 +1,32 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+type MCPERemoveEntity struct {
+	EntityId int64
+}
+
+func (*MCPERemoveEntity) Id() byte {
+	return ID_MCPE_REMOVE_ENTITY
+}
+
+func (pkt *MCPERemoveEntity) Decode(reader io.Reader) (err error) {
+	eid, err := raknet.ReadInt64(reader)
+	if err != nil {
+		return
+	}
+	pkt.EntityId = eid
+	return
+}
+
+func (pkt MCPERemoveEntity) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_REMOVE_ENTITY)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt64(writer, pkt.EntityId)
+	return
+}
@@ -0,0 +1,39 @@
+package mcpe
+
+import (
+	"../raknet"
+	"github.com/pborman/uuid"
+	"io"
+)
+
+type MCPERemovePlayer struct {
+	EntityId   int64
+	ClientUuid uuid.UUID
+}
+
+func (*MCPERemovePlayer) Id() byte {
+	return ID_MCPE_REMOVE_PLAYER
+}
+
+func (pkt *MCPERemovePlayer) Decode(reader io.Reader) (err error) {
+	eid, err := raknet.ReadInt64(reader)
+	if err != nil {
+		return
+	}
+	pkt.EntityId = eid
+	pkt.ClientUuid, err = ReadUUID(reader)
+	return
+}
+
+func (pkt MCPERemovePlayer) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_REMOVE_PLAYER)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt64(writer, pkt.EntityId)
+	if err != nil {
+		return
+	}
+	err = WriteUUID(writer, pkt.ClientUuid)
+	return
+}
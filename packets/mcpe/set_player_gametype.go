@@ -0,0 +1,32 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+type MCPESetPlayerGametype struct {
+	Gamemode int32
+}
+
+func (*MCPESetPlayerGametype) Id() byte {
+	return ID_MCPE_SET_PLAYER_GAMETYPE
+}
+
+func (pkt *MCPESetPlayerGametype) Decode(reader io.Reader) (err error) {
+	gm, err := raknet.ReadInt32(reader)
+	if err != nil {
+		return
+	}
+	pkt.Gamemode = gm
+	return
+}
+
+func (pkt MCPESetPlayerGametype) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_SET_PLAYER_GAMETYPE)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt32(writer, pkt.Gamemode)
+	return
+}
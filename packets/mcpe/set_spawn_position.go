@@ -0,0 +1,32 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+type MCPESetSpawnPosition struct {
+	Coordinates BlockCoordinates
+}
+
+func (*MCPESetSpawnPosition) Id() byte {
+	return ID_MCPE_SET_SPAWN_POSITION
+}
+
+func (pkt *MCPESetSpawnPosition) Decode(reader io.Reader) (err error) {
+	c, err := NewBlockCoordinates(reader)
+	if err != nil {
+		return
+	}
+	pkt.Coordinates = c
+	return
+}
+
+func (pkt MCPESetSpawnPosition) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_SET_SPAWN_POSITION)
+	if err != nil {
+		return
+	}
+	err = pkt.Coordinates.Write(writer)
+	return
+}
@@ -0,0 +1,42 @@
+package mcpe
+
+import (
+	"../raknet"
+	"io"
+)
+
+type MCPESetTime struct {
+	Time    int32
+	Started bool
+}
+
+func (*MCPESetTime) Id() byte {
+	return ID_MCPE_SET_TIME
+}
+
+func (pkt *MCPESetTime) Decode(reader io.Reader) (err error) {
+	t, err := raknet.ReadInt32(reader)
+	if err != nil {
+		return
+	}
+	s, err := raknet.ReadBoolean(reader)
+	if err != nil {
+		return
+	}
+	pkt.Time = t
+	pkt.Started = s
+	return
+}
+
+func (pkt MCPESetTime) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_SET_TIME)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteInt32(writer, pkt.Time)
+	if err != nil {
+		return
+	}
+	err = raknet.WriteBoolean(writer, pkt.Started)
+	return
+}
@@ -66,6 +66,10 @@ func (pkt *MCPEStartGame) Decode(reader io.Reader) (err error) {
 }
 
 func (pkt MCPEStartGame) Encode(writer io.Writer) (err error) {
+	err = raknet.WriteByte(writer, ID_MCPE_START_GAME)
+	if err != nil {
+		return
+	}
 	err = raknet.WriteInt32(writer, pkt.Seed)
 	if err != nil {
 		return
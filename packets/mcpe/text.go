@@ -35,11 +35,10 @@ func (pkt *MCPEText) Decode(reader io.Reader) (err error) {
 
 	if rt == TEXT_TYPE_CHAT {
 		s, e := raknet.ReadString(reader)
-		pkt.Sender = s
-
-		if err != nil {
+		if e != nil {
 			return e
 		}
+		pkt.Sender = s
 	}
 
 	m, err := raknet.ReadString(reader)
@@ -69,7 +69,7 @@ func (pkt RakNetAck) Encode(writer io.Writer) (err error) {
 	return
 }
 
-func (pkt RakNetAck) Decode(reader io.Reader) (err error) {
+func (pkt *RakNetAck) Decode(reader io.Reader) (err error) {
 	sliced := make([]Range, 0)
 	count, err := ReadInt16(reader)
 	if err != nil {
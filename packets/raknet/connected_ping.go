@@ -16,7 +16,7 @@ func NewConnectedPingWithCurrentTime() RakNetConnectedPing {
 	return RakNetConnectedPing{GetTimeMilliseconds()}
 }
 
-func (pkt RakNetConnectedPing) Decode(reader io.Reader) (err error) {
+func (pkt *RakNetConnectedPing) Decode(reader io.Reader) (err error) {
 	ts, err := ReadInt64(reader)
 	pkt.Timestamp = ts
 	return err
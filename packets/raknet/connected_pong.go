@@ -13,7 +13,7 @@ func (pkt RakNetConnectedPong) Id() byte {
 	return ID_CONNECTED_PONG
 }
 
-func (pkt RakNetConnectedPong) Decode(reader io.Reader) (err error) {
+func (pkt *RakNetConnectedPong) Decode(reader io.Reader) (err error) {
 	ts, err := ReadInt64(reader)
 	pkt.Timestamp1 = ts
 	ts2, err := ReadInt64(reader)
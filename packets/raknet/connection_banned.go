@@ -0,0 +1,42 @@
+package raknet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Sent instead of an open connection reply when the client is banned.
+type RakNetConnectionBanned struct {
+	GUID int64
+}
+
+func (pkt RakNetConnectionBanned) Id() byte {
+	return ID_CONNECTION_BANNED
+}
+
+func (pkt *RakNetConnectionBanned) Decode(reader io.Reader) (err error) {
+	magic := make([]byte, 16)
+	_, err = reader.Read(magic)
+
+	if !bytes.Equal(magic, MAGIC) {
+		return errors.New("Offline magic not valid.")
+	}
+
+	guid, err := ReadInt64(reader)
+	pkt.GUID = guid
+	return
+}
+
+func (pkt RakNetConnectionBanned) Encode(writer io.Writer) (err error) {
+	err = WriteByte(writer, ID_CONNECTION_BANNED)
+	if err != nil {
+		return
+	}
+	_, err = writer.Write(MAGIC)
+	if err != nil {
+		return
+	}
+	err = WriteInt64(writer, pkt.GUID)
+	return
+}
@@ -41,6 +41,9 @@ func CreateDatagrams(rn *util.AtomicInteger, dsn *util.AtomicInteger, pkt Encoda
 	// Try to encode the encapsulated packets.
 	thisPktBuf := new(bytes.Buffer)
 	for _, p := range *encapsulated {
+		// Each datagram keeps a pointer to its parts, so they mustn't all point
+		// at the loop variable.
+		p := p
 		err = p.Encode(thisPktBuf)
 		if err != nil {
 			return nil, err
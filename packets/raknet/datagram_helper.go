@@ -21,6 +21,8 @@ type sentDatagram struct {
 type DatagramHelper struct {
 	sentDatagrams map[int32]*sentDatagram
 	toSend        DatagramSender
+	// INTERNAL: smoothed round trip time, measured from acks
+	rtt time.Duration
 	sync.Mutex
 }
 
@@ -50,8 +52,13 @@ func (this *DatagramHelper) HandleAck(ack *RakNetAck) {
 	for _, item := range ack.Acknowledged {
 		for id := item.Min; id <= item.Max; id++ {
 			as32 := int32(id)
-			if _, ok := this.sentDatagrams[as32]; ok {
+			if sent, ok := this.sentDatagrams[as32]; ok {
 				log.Printf("Marked %d as ACK.", id)
+				// We can't tell which copy of a resent datagram was acked, so
+				// only the ones sent once are timed.
+				if sent.tries == 0 {
+					this.sampleRoundTripTime(time.Since(sent.sent))
+				}
 				delete(this.sentDatagrams, as32)
 			} else {
 				log.Printf("Tried to ack unknown datagram %d!", id)
@@ -61,6 +68,25 @@ func (this *DatagramHelper) HandleAck(ack *RakNetAck) {
 	this.Unlock()
 }
 
+// Must be called with the lock held.
+func (this *DatagramHelper) sampleRoundTripTime(sample time.Duration) {
+	if this.rtt == 0 {
+		this.rtt = sample
+	} else {
+		// Like TCP, smooth it out so one slow ack doesn't throw it off.
+		this.rtt = (7*this.rtt + sample) / 8
+	}
+}
+
+// Returns how long the other end takes to ack a datagram, or 0 if nothing has
+// been acked yet. Acks are sent in batches, so this is a little higher than
+// the actual latency.
+func (this *DatagramHelper) RoundTripTime() time.Duration {
+	this.Lock()
+	defer this.Unlock()
+	return this.rtt
+}
+
 func (this *DatagramHelper) HandleNak(nak *RakNetNak) {
 	this.Lock()
 	for _, item := range nak.NotAcknowledged {
@@ -68,6 +94,7 @@ func (this *DatagramHelper) HandleNak(nak *RakNetNak) {
 			as32 := int32(id)
 			if _, ok := this.sentDatagrams[as32]; ok {
 				log.Printf("Marked %d as NAK!", id)
+				datagramsNacked.Inc()
 				delete(this.sentDatagrams, as32)
 			} else {
 				log.Printf("Tried to nak unknown datagram %d!", id)
@@ -98,6 +125,7 @@ func (this *DatagramHelper) TryResendPackets() {
 		if v.sent.Add(delay).Before(now) {
 			if v.tries >= 2 {
 				log.Printf("Datagram %d lost for %s.", k, this.toSend.GetEndpointString())
+				datagramsLost.With("timeout").Inc()
 				delete(this.sentDatagrams, k)
 				continue
 			}
@@ -105,10 +133,12 @@ func (this *DatagramHelper) TryResendPackets() {
 			if err := this.toSend.SendPacket(v.data); err != nil {
 				log.Printf("Datagram %d lost for %s due to error: %s", k,
 					this.toSend.GetEndpointString(), err.Error())
+				datagramsLost.With("error").Inc()
 				delete(this.sentDatagrams, k)
 				continue
 			}
 
+			datagramsResent.Inc()
 			v.sent = now
 			v.tries = v.tries + 1
 		}
@@ -0,0 +1,22 @@
+package raknet
+
+import "../../metrics"
+
+var (
+	datagramsResent = metrics.NewCounter("raknet_datagrams_resent_total",
+		"Datagrams sent again because they weren't acked in time.")
+	datagramsLost = metrics.NewCounterVec("raknet_datagrams_lost_total",
+		"Datagrams given up on, because they were never acked or couldn't be sent.", "reason")
+	datagramsNacked = metrics.NewCounter("raknet_datagrams_nak_total",
+		"Datagrams the other end said it didn't get.")
+	splitPacketsReassembled = metrics.NewCounter("raknet_split_packets_reassembled_total",
+		"Packets put back together from all their parts.")
+	splitPartsExpired = metrics.NewCounter("raknet_split_parts_expired_total",
+		"Parts of split packets thrown away because the rest never came.")
+)
+
+func init() {
+	// So they show up as 0 before anything is lost.
+	datagramsLost.With("timeout")
+	datagramsLost.With("error")
+}
@@ -13,7 +13,7 @@ func (RakNetNak) Id() byte {
 }
 
 func (pkt RakNetNak) Encode(writer io.Writer) (err error) {
-	err = WriteByte(writer, ID_ACK)
+	err = WriteByte(writer, ID_NAK)
 
 	err = WriteInt16(writer, int16(len(pkt.NotAcknowledged)))
 
@@ -32,7 +32,7 @@ func (pkt RakNetNak) Encode(writer io.Writer) (err error) {
 	return
 }
 
-func (pkt RakNetNak) Decode(reader io.Reader) (err error) {
+func (pkt *RakNetNak) Decode(reader io.Reader) (err error) {
 	sliced := make([]Range, 0)
 	count, err := ReadInt16(reader)
 	if err != nil {
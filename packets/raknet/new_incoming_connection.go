@@ -16,7 +16,7 @@ func (pkt RakNetNewIncomingConnection) Id() byte {
 	return ID_NEW_INCOMING_CONNECTION
 }
 
-func (pkt RakNetNewIncomingConnection) Decode(reader io.Reader) (err error) {
+func (pkt *RakNetNewIncomingConnection) Decode(reader io.Reader) (err error) {
 	cookie, err := ReadInt32(reader)
 	secure, err := ReadByte(reader)
 	port, err := ReadInt16(reader)
@@ -33,7 +33,7 @@ func (pkt RakNetOpenConnectionRequest1) Encode(writer io.Writer) (err error) {
 	// Ideally, all Encode functions should look like this.
 	data := make([]byte, pkt.MTUFill)
 	data[0] = ID_OPEN_CONNECTION_REQUEST_1
-	copy(data[1:16], MAGIC)
+	copy(data[1:17], MAGIC)
 	data[17] = pkt.ProtocolVersion
 	_, err = writer.Write(data)
 	return
@@ -2,12 +2,17 @@ package raknet
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"net"
 )
 
 type RakNetOpenConnectionRequest2 struct {
+	// Set if the server's first reply was secure, in which case the cookie
+	// from that reply is echoed.
+	Secure         bool
+	Cookie         uint32
 	ClientEndpoint *net.UDPAddr
 	MTU            int16
 	GUID           int64
@@ -25,10 +30,44 @@ func (pkt *RakNetOpenConnectionRequest2) Decode(reader io.Reader) (err error) {
 		return errors.New("Offline magic not valid.")
 	}
 
-	addr, err := ReadUDPAddr(reader)
+	// The address starts with its version (4). Anything else is a cookie,
+	// which may be followed by a zero byte saying the client didn't write a
+	// security challenge. Cookies we hand out never start with 4.
+	version, err := ReadByte(reader)
 	if err != nil {
 		return
 	}
+	if version != 4 {
+		rest := make([]byte, 3)
+		if _, err = io.ReadFull(reader, rest); err != nil {
+			return
+		}
+		pkt.Secure = true
+		pkt.Cookie = binary.BigEndian.Uint32(append([]byte{version}, rest...))
+
+		if version, err = ReadByte(reader); err != nil {
+			return
+		}
+		if version == 0 {
+			if version, err = ReadByte(reader); err != nil {
+				return
+			}
+		}
+	}
+	if version != 4 {
+		return errors.New("Only IPv4 client endpoints are supported.")
+	}
+
+	ip := make([]byte, 4)
+	if _, err = io.ReadFull(reader, ip); err != nil {
+		return
+	}
+	port, err := ReadUint16(reader)
+	if err != nil {
+		return
+	}
+	addr := net.UDPAddr{IP: net.IP(ip), Port: int(port)}
+
 	mtu, err := ReadInt16(reader)
 	if err != nil {
 		return
@@ -54,6 +93,17 @@ func (pkt RakNetOpenConnectionRequest2) Encode(writer io.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	if pkt.Secure {
+		err = WriteUint32(writer, pkt.Cookie)
+		if err != nil {
+			return
+		}
+		// We never write a security challenge.
+		err = WriteByte(writer, 0)
+		if err != nil {
+			return
+		}
+	}
 	err = WriteUDPAddr(writer, *pkt.ClientEndpoint)
 	if err != nil {
 		return
@@ -9,6 +9,8 @@ import (
 type RakNetOpenConnectionReply1 struct {
 	GUID   int64
 	Secure byte
+	// Only sent if Secure is set. The client echoes it in its second request.
+	Cookie uint32
 	MTU    int16
 }
 
@@ -40,6 +42,13 @@ func (pkt *RakNetOpenConnectionReply1) Decode(reader io.Reader) (err error) {
 	if err != nil {
 		return
 	}
+	if secure != 0 {
+		cookie, err := ReadUint32(reader)
+		if err != nil {
+			return err
+		}
+		pkt.Cookie = cookie
+	}
 	mtu, err := ReadInt16(reader)
 	if err != nil {
 		return
@@ -69,6 +78,12 @@ func (pkt RakNetOpenConnectionReply1) Encode(writer io.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	if pkt.Secure != 0 {
+		err = WriteUint32(writer, pkt.Cookie)
+		if err != nil {
+			return
+		}
+	}
 	err = WriteInt16(writer, pkt.MTU)
 	if err != nil {
 		return
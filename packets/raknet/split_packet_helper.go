@@ -38,6 +38,11 @@ func (sph *SplitPacketHandler) GarbageCollect() {
 
 	for k, v := range sph.splitPackets {
 		if now.After(v.expiration) {
+			for _, part := range v.packets {
+				if part != nil {
+					splitPartsExpired.Inc()
+				}
+			}
 			delete(sph.splitPackets, k)
 		}
 	}
@@ -79,5 +84,6 @@ func (sph *SplitPacketHandler) AcceptSplitPacket(pkt EncapsulatedPacketPart) *[]
 	}
 
 	delete(sph.splitPackets, pkt.PartId)
+	splitPacketsReassembled.Inc()
 	return &allSplit.packets
 }
@@ -1,6 +1,8 @@
 package raknet
 
 import (
+	"bytes"
+	"errors"
 	"io"
 )
 
@@ -42,3 +44,31 @@ func (pkt RakNetUnconnectedPong) Encode(writer io.Writer) (err error) {
 	err = WriteString(writer, pkt.Name)
 	return
 }
+
+func (pkt *RakNetUnconnectedPong) Decode(reader io.Reader) (err error) {
+	pingId, err := ReadInt64(reader)
+	if err != nil {
+		return
+	}
+	serverId, err := ReadInt64(reader)
+	if err != nil {
+		return
+	}
+	magic := make([]byte, 16)
+	_, err = reader.Read(magic)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(magic, MAGIC) {
+		return errors.New("Offline magic not valid.")
+	}
+	name, err := ReadString(reader)
+	if err != nil {
+		return
+	}
+
+	pkt.PingId = pingId
+	pkt.ServerId = serverId
+	pkt.Name = name
+	return
+}
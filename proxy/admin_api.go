@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type AdminApiConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+	// Clients send it in an "Authorization: Bearer <token>" header.
+	Token string `toml:"token"`
+}
+
+// A JSON API for managing the proxy from other programs, like a web panel.
+type AdminApi struct {
+	proxy  *Proxy
+	server *http.Server
+}
+
+type apiSession struct {
+	Username string `json:"username"`
+	UUID     string `json:"uuid"`
+	Endpoint string `json:"endpoint"`
+	// Empty while the player is connecting.
+	Server string `json:"server"`
+	// In milliseconds.
+	Ping int64 `json:"ping"`
+}
+
+type apiServer struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Restricted bool   `json:"restricted"`
+	Online     bool   `json:"online"`
+	// In milliseconds, as of the last health check.
+	Latency int64 `json:"latency"`
+	// Players the proxy has on the server.
+	Players int `json:"players"`
+	// What the server itself reported in the last health check.
+	ReportedPlayers    int `json:"reported_players"`
+	ReportedMaxPlayers int `json:"reported_max_players"`
+}
+
+func NewAdminApi(proxy *Proxy) (this *AdminApi) {
+	this = new(AdminApi)
+	this.proxy = proxy
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", this.authenticated(this.handleSessions))
+	mux.HandleFunc("/servers", this.authenticated(this.handleServers))
+	mux.HandleFunc("/kick", this.authenticated(this.handleKick))
+	mux.HandleFunc("/send", this.authenticated(this.handleSend))
+	mux.HandleFunc("/broadcast", this.authenticated(this.handleBroadcast))
+	mux.HandleFunc("/bans", this.authenticated(this.handleBans))
+
+	this.server = &http.Server{
+		Addr:         proxy.Config().AdminApi.Listen,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return
+}
+
+func (this *AdminApi) ListenAndServe() {
+	log.Printf("Admin API listening on %s", this.server.Addr)
+	if err := this.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Unable to serve the admin API: %s", err.Error())
+	}
+}
+
+func (this *AdminApi) Close() error {
+	return this.server.Close()
+}
+
+// Turns away requests without the right token.
+func (this *AdminApi) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := this.proxy.Config().AdminApi.Token
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeApiError(w, http.StatusUnauthorized, "Missing or wrong token.")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeApiJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, msg string) {
+	writeApiJson(w, status, map[string]string{"error": msg})
+}
+
+// Checks the method, and decodes the JSON body of POST requests into body.
+// Writes an error and returns false if the request is no good.
+func readApiRequest(w http.ResponseWriter, r *http.Request, body interface{}, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method != method {
+			continue
+		}
+		if r.Method == http.MethodPost && body != nil {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(body); err != nil {
+				writeApiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %s", err.Error()))
+				return false
+			}
+		}
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeApiError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	return false
+}
+
+// GET /sessions lists the players on the proxy.
+func (this *AdminApi) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !readApiRequest(w, r, nil, http.MethodGet) {
+		return
+	}
+
+	sessions := []apiSession{}
+	for _, session := range this.proxy.Registry.Players() {
+		s := apiSession{
+			Username: session.Username(),
+			Endpoint: session.Endpoint().String(),
+			Ping:     int64(session.Ping() / time.Millisecond),
+		}
+		if id := session.UUID(); id != nil {
+			s.UUID = id.String()
+		}
+		if server := session.CurrentServer(); server != nil {
+			s.Server = server.Name
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Username < sessions[j].Username
+	})
+	writeApiJson(w, http.StatusOK, sessions)
+}
+
+// GET /servers lists the servers, with their health and player counts.
+func (this *AdminApi) handleServers(w http.ResponseWriter, r *http.Request) {
+	if !readApiRequest(w, r, nil, http.MethodGet) {
+		return
+	}
+
+	players := make(map[*Server]int)
+	for _, session := range this.proxy.Registry.Players() {
+		if server := session.CurrentServer(); server != nil {
+			players[server]++
+		}
+	}
+
+	servers := []apiServer{}
+	for _, server := range this.proxy.Servers() {
+		status := server.Status()
+		servers = append(servers, apiServer{
+			Name:               server.Name,
+			Address:            server.Address.String(),
+			Restricted:         server.Restricted,
+			Online:             status.Online,
+			Latency:            int64(status.Latency / time.Millisecond),
+			Players:            players[server],
+			ReportedPlayers:    status.Players,
+			ReportedMaxPlayers: status.MaxPlayers,
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+	writeApiJson(w, http.StatusOK, servers)
+}
+
+// POST /kick {"player": "Steve", "reason": "..."} disconnects a player.
+func (this *AdminApi) handleKick(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Player string `json:"player"`
+		Reason string `json:"reason"`
+	}
+	if !readApiRequest(w, r, &body, http.MethodPost) {
+		return
+	}
+
+	session := this.proxy.Registry.FindByUsername(body.Player)
+	if session == nil {
+		writeApiError(w, http.StatusNotFound, fmt.Sprintf("%s isn't online.", body.Player))
+		return
+	}
+	if body.Reason == "" {
+		body.Reason = "Kicked from the network"
+	}
+	session.AbandonWithReason(body.Reason)
+	writeApiJson(w, http.StatusOK, map[string]string{"kicked": session.Username()})
+}
+
+// POST /send {"player": "Steve", "server": "games"} moves a player to a
+// server or group. Connecting can take a while, so this doesn't wait for it;
+// the player is told if it fails.
+func (this *AdminApi) handleSend(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Player string `json:"player"`
+		Server string `json:"server"`
+	}
+	if !readApiRequest(w, r, &body, http.MethodPost) {
+		return
+	}
+
+	session := this.proxy.Registry.FindByUsername(body.Player)
+	if session == nil {
+		writeApiError(w, http.StatusNotFound, fmt.Sprintf("%s isn't online.", body.Player))
+		return
+	}
+	chain, err := session.switchChain(body.Server)
+	if err != nil {
+		writeApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	go func() {
+		if err := session.ConnectAny(chain); err != nil {
+			log.Printf("Unable to send %s to %s: %s", session.Username(), body.Server, err.Error())
+			session.SendMessage(err.Error())
+		}
+	}()
+	writeApiJson(w, http.StatusAccepted, map[string]string{"player": session.Username(), "server": body.Server})
+}
+
+// POST /broadcast {"message": "..."} sends a chat message to every player.
+func (this *AdminApi) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if !readApiRequest(w, r, &body, http.MethodPost) {
+		return
+	}
+	if body.Message == "" {
+		writeApiError(w, http.StatusBadRequest, "No message given.")
+		return
+	}
+	writeApiJson(w, http.StatusOK, map[string]int{"sent": this.proxy.Broadcast(body.Message)})
+}
+
+// GET /bans lists bans.
+// POST /bans {"target": "Steve", "reason": "...", "duration": "12h"} bans a
+// player, UUID, address or range. Leave out the duration to ban for good.
+// DELETE /bans?target=Steve lifts a ban.
+func (this *AdminApi) handleBans(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Target   string `json:"target"`
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if !readApiRequest(w, r, &body, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bans := this.proxy.Bans.All()
+		if bans == nil {
+			bans = []Ban{}
+		}
+		writeApiJson(w, http.StatusOK, bans)
+	case http.MethodPost:
+		if body.Target == "" {
+			writeApiError(w, http.StatusBadRequest, "No target given.")
+			return
+		}
+		var duration time.Duration
+		if body.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(body.Duration); err != nil || duration <= 0 {
+				writeApiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid duration %q.", body.Duration))
+				return
+			}
+		}
+		ban := NewBan(body.Target, body.Reason, "API", duration)
+		kicked, err := this.proxy.Ban(ban)
+		if err != nil {
+			writeApiError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to save bans: %s", err.Error()))
+			return
+		}
+		writeApiJson(w, http.StatusOK, map[string]interface{}{"ban": ban, "kicked": kicked})
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		removed, err := this.proxy.Bans.Remove(target)
+		if err != nil {
+			writeApiError(w, http.StatusInternalServerError, fmt.Sprintf("Unable to save bans: %s", err.Error()))
+			return
+		}
+		if !removed {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("%s isn't banned.", target))
+			return
+		}
+		writeApiJson(w, http.StatusOK, map[string]string{"unbanned": target})
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BanType string
+
+const (
+	BAN_IP   BanType = "ip"
+	BAN_CIDR BanType = "cidr"
+	BAN_NAME BanType = "name"
+	BAN_UUID BanType = "uuid"
+)
+
+type Ban struct {
+	Type    BanType    `json:"type"`
+	Target  string     `json:"target"`
+	Reason  string     `json:"reason"`
+	Issuer  string     `json:"issuer"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Works out what kind of ban a target (an IP address, CIDR range, UUID or
+// username) needs, and normalizes it.
+func NewBan(target string, reason string, issuer string, duration time.Duration) *Ban {
+	ban := &Ban{
+		Target:  target,
+		Reason:  reason,
+		Issuer:  issuer,
+		Created: time.Now(),
+	}
+
+	if ip := net.ParseIP(target); ip != nil {
+		ban.Type = BAN_IP
+		ban.Target = ip.String()
+	} else if _, network, err := net.ParseCIDR(target); err == nil {
+		ban.Type = BAN_CIDR
+		ban.Target = network.String()
+	} else if id := uuid.Parse(target); id != nil {
+		ban.Type = BAN_UUID
+		ban.Target = id.String()
+	} else {
+		ban.Type = BAN_NAME
+		ban.Target = strings.ToLower(target)
+	}
+
+	if duration > 0 {
+		expires := ban.Created.Add(duration)
+		ban.Expires = &expires
+	}
+	return ban
+}
+
+func (this *Ban) IsExpired() bool {
+	return this.Expires != nil && time.Now().After(*this.Expires)
+}
+
+// The message shown to banned players.
+func (this *Ban) Message() string {
+	msg := "You are banned from this network"
+	if this.Reason != "" {
+		msg += ": " + this.Reason
+	}
+	if this.Expires != nil {
+		msg += fmt.Sprintf(" (until %s)", this.Expires.Format("2006-01-02 15:04 MST"))
+	}
+	return msg
+}
+
+func (this *Ban) matchesAddress(ip net.IP) bool {
+	switch this.Type {
+	case BAN_IP:
+		return this.Target == ip.String()
+	case BAN_CIDR:
+		_, network, err := net.ParseCIDR(this.Target)
+		return err == nil && network.Contains(ip)
+	}
+	return false
+}
+
+func (this *Ban) matchesPlayer(username string, id uuid.UUID) bool {
+	switch this.Type {
+	case BAN_NAME:
+		return this.Target == strings.ToLower(username)
+	case BAN_UUID:
+		return id != nil && this.Target == id.String()
+	}
+	return false
+}
+
+// Whether a ban applies to a session.
+func (this *Ban) Applies(session *Session) bool {
+	if this.IsExpired() {
+		return false
+	}
+	if this.matchesAddress(session.endpoint.IP) {
+		return true
+	}
+	if session.username == nil {
+		return false
+	}
+	var id uuid.UUID
+	if session.uuid != nil {
+		id = *session.uuid
+	}
+	return this.matchesPlayer(*session.username, id)
+}
+
+// A list of bans, persisted to a JSON file.
+type BanList struct {
+	sync.RWMutex
+	path string
+	bans []*Ban
+}
+
+// Loads the ban list from a file. A missing file is an empty ban list.
+func LoadBanList(path string) (this *BanList, err error) {
+	this = new(BanList)
+	this.path = path
+	if err = this.Reload(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Re-reads the ban list from its file.
+func (this *BanList) Reload() error {
+	var bans []*Ban
+	data, err := ioutil.ReadFile(this.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &bans); err != nil {
+			return fmt.Errorf("Unable to parse %s: %s", this.path, err.Error())
+		}
+	}
+
+	this.Lock()
+	this.bans = bans
+	this.Unlock()
+	return nil
+}
+
+// Must be called with the lock held.
+func (this *BanList) save() error {
+	return writeJSONFile(this.path, this.bans)
+}
+
+// Adds a ban, replacing any existing ban on the same target.
+func (this *BanList) Add(ban *Ban) error {
+	this.Lock()
+	defer this.Unlock()
+
+	bans := this.bans[:0:0]
+	for _, existing := range this.bans {
+		if existing.Type != ban.Type || existing.Target != ban.Target {
+			bans = append(bans, existing)
+		}
+	}
+	this.bans = append(bans, ban)
+	return this.save()
+}
+
+// Removes the ban on a target. Returns whether there was one.
+func (this *BanList) Remove(target string) (bool, error) {
+	normalized := NewBan(target, "", "", 0)
+
+	this.Lock()
+	defer this.Unlock()
+
+	bans := this.bans[:0:0]
+	for _, existing := range this.bans {
+		if existing.Type != normalized.Type || existing.Target != normalized.Target {
+			bans = append(bans, existing)
+		}
+	}
+	if len(bans) == len(this.bans) {
+		return false, nil
+	}
+	this.bans = bans
+	return true, this.save()
+}
+
+// Returns all bans that haven't expired.
+func (this *BanList) All() (bans []Ban) {
+	this.RLock()
+	defer this.RUnlock()
+	for _, ban := range this.bans {
+		if !ban.IsExpired() {
+			bans = append(bans, *ban)
+		}
+	}
+	return
+}
+
+// Returns the ban that applies to an address, if any.
+func (this *BanList) CheckAddress(ip net.IP) *Ban {
+	this.RLock()
+	defer this.RUnlock()
+	for _, ban := range this.bans {
+		if !ban.IsExpired() && ban.matchesAddress(ip) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// Returns the ban that applies to a player, if any.
+func (this *BanList) CheckPlayer(username string, id uuid.UUID) *Ban {
+	this.RLock()
+	defer this.RUnlock()
+	for _, ban := range this.bans {
+		if !ban.IsExpired() && ban.matchesPlayer(username, id) {
+			return ban
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"../packets/mcpe"
+	"encoding/binary"
+	"sync"
+)
+
+type ChunkPos struct {
+	X, Z int32
+}
+
+// Keeps track of which chunks a server has sent the client, so they can be
+// emptied again when the player switches servers. The client would otherwise
+// keep showing parts of the old server's world.
+type ChunkTracker struct {
+	sync.Mutex
+	// INTERNAL
+	chunks map[ChunkPos]struct{}
+}
+
+func NewChunkTracker() (this *ChunkTracker) {
+	this = new(ChunkTracker)
+	this.chunks = make(map[ChunkPos]struct{})
+	return
+}
+
+// Looks at a packet on its way to the client.
+func (this *ChunkTracker) Track(pkt []byte) {
+	if len(pkt) == 0 {
+		return
+	}
+
+	switch pkt[0] {
+	case mcpe.ID_MCPE_FULL_CHUNK_DATA:
+		if len(pkt) < 9 {
+			return
+		}
+		pos := ChunkPos{
+			X: int32(binary.BigEndian.Uint32(pkt[1:])),
+			Z: int32(binary.BigEndian.Uint32(pkt[5:])),
+		}
+		this.Lock()
+		this.chunks[pos] = struct{}{}
+		this.Unlock()
+	case mcpe.ID_MCPE_CHANGE_DIMENSION:
+		// The client drops all of its chunks when it changes dimension.
+		this.Unload()
+	}
+}
+
+// Returns every chunk sent so far, and forgets about them.
+func (this *ChunkTracker) Unload() (chunks []ChunkPos) {
+	this.Lock()
+	defer this.Unlock()
+
+	for pos := range this.chunks {
+		chunks = append(chunks, pos)
+	}
+	this.chunks = make(map[ChunkPos]struct{})
+	return
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Someone who can run commands: a player, or the console.
+type CommandSender interface {
+	Name() string
+	SendMessage(msg string) error
+	HasPermission(node string) bool
+}
+
+type Command struct {
+	Name    string
+	Aliases []string
+	// The arguments it takes, e.g. "server [name]".
+	Usage       string
+	Description string
+	// The permission node needed to run it. Everyone may run commands without
+	// one.
+	Permission string
+	// Returns false if the arguments were wrong, in which case the usage is
+	// shown.
+	Handler func(sender CommandSender, args []string) bool
+}
+
+// Whether a sender may run this command.
+func (this *Command) Allows(sender CommandSender) bool {
+	return this.Permission == "" || sender.HasPermission(this.Permission)
+}
+
+// Commands handled by the proxy itself, rather than the server a player is
+// on. Players run them from chat, and the console shares them too.
+type CommandRegistry struct {
+	sync.RWMutex
+	// INTERNAL: by name and by alias, all lower case
+	commands map[string]*Command
+}
+
+func NewCommandRegistry() (this *CommandRegistry) {
+	this = new(CommandRegistry)
+	this.commands = make(map[string]*Command)
+	return
+}
+
+// Adds a command. Fails if its name or one of its aliases is taken.
+func (this *CommandRegistry) Register(cmd *Command) error {
+	this.Lock()
+	defer this.Unlock()
+
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := this.commands[strings.ToLower(name)]; ok {
+			return fmt.Errorf("There already is a command called %s.", name)
+		}
+	}
+	for _, name := range names {
+		this.commands[strings.ToLower(name)] = cmd
+	}
+	return nil
+}
+
+// Looks up a command by name or alias.
+func (this *CommandRegistry) Get(name string) *Command {
+	this.RLock()
+	defer this.RUnlock()
+	return this.commands[strings.ToLower(name)]
+}
+
+// Returns every command a sender may run, sorted by name.
+func (this *CommandRegistry) Available(sender CommandSender) (cmds []*Command) {
+	this.RLock()
+	defer this.RUnlock()
+
+	for name, cmd := range this.commands {
+		if name == strings.ToLower(cmd.Name) && cmd.Allows(sender) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return
+}
+
+// Returns the names and aliases starting with prefix of the commands a sender
+// may run, sorted.
+func (this *CommandRegistry) Complete(sender CommandSender, prefix string) (names []string) {
+	this.RLock()
+	defer this.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	for name, cmd := range this.commands {
+		if strings.HasPrefix(name, prefix) && cmd.Allows(sender) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+var ErrUnknownCommand = errors.New("Unknown command.")
+
+// Runs a command line, without the leading slash. Returns ErrUnknownCommand if
+// there is no such command, which lets players' commands go on to their
+// server. Everything else, including a missing permission, is reported to the
+// sender.
+func (this *CommandRegistry) Dispatch(sender CommandSender, line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return ErrUnknownCommand
+	}
+	cmd := this.Get(args[0])
+	if cmd == nil {
+		return ErrUnknownCommand
+	}
+
+	if !cmd.Allows(sender) {
+		return sender.SendMessage(fmt.Sprintf("You don't have permission to use %s.", cmd.Name))
+	}
+	if !cmd.Handler(sender, args[1:]) {
+		return sender.SendMessage("Usage: " + cmd.Usage)
+	}
+	return nil
+}
+
+// The commands every proxy has.
+func (this *Proxy) registerCommands() {
+	this.Commands.Register(&Command{
+		Name:        "help",
+		Aliases:     []string{"?"},
+		Usage:       "help [command]",
+		Description: "Lists the proxy's commands, or explains one.",
+		Permission:  "proxy.command.help",
+		Handler:     this.helpCommand,
+	})
+	this.Commands.Register(&Command{
+		Name:        "server",
+		Usage:       "server [name]",
+		Description: "Shows the servers, or moves you to one.",
+		Permission:  "proxy.command.server",
+		Handler:     this.serverCommand,
+	})
+}
+
+// help lists commands. help <command> explains a command, and help <prefix>
+// lists the commands starting with it.
+func (this *Proxy) helpCommand(sender CommandSender, args []string) bool {
+	if len(args) > 1 {
+		return false
+	}
+
+	if len(args) == 1 {
+		if cmd := this.Commands.Get(args[0]); cmd != nil && cmd.Allows(sender) {
+			sender.SendMessage(fmt.Sprintf("%s: %s", cmd.Usage, cmd.Description))
+			if len(cmd.Aliases) > 0 {
+				sender.SendMessage("Aliases: " + strings.Join(cmd.Aliases, ", "))
+			}
+			return true
+		}
+		names := this.Commands.Complete(sender, args[0])
+		if len(names) == 0 {
+			sender.SendMessage(fmt.Sprintf("No commands start with %s.", args[0]))
+		} else {
+			sender.SendMessage("Commands: " + strings.Join(names, ", "))
+		}
+		return true
+	}
+
+	for _, cmd := range this.Commands.Available(sender) {
+		sender.SendMessage(fmt.Sprintf("%s: %s", cmd.Usage, cmd.Description))
+	}
+	return true
+}
+
+// server lists the servers, server <name> moves the player to one.
+func (this *Proxy) serverCommand(sender CommandSender, args []string) bool {
+	if len(args) > 1 {
+		return false
+	}
+
+	session, _ := sender.(*Session)
+	var current *Server
+	if session != nil {
+		current = session.CurrentServer()
+	}
+
+	if len(args) == 0 {
+		var names []string
+		for _, server := range this.Servers() {
+			if session == nil || session.CanJoin(server) {
+				names = append(names, server.Name)
+			}
+		}
+		sort.Strings(names)
+		if current != nil {
+			sender.SendMessage(fmt.Sprintf("You are on %s.", current.Name))
+		}
+		sender.SendMessage("Servers: " + strings.Join(names, ", "))
+		return true
+	}
+
+	if session == nil {
+		sender.SendMessage("Only players can switch servers.")
+		return true
+	}
+
+	target := args[0]
+	if current != nil && current.Name == target {
+		sender.SendMessage(fmt.Sprintf("You are already connected to %s.", target))
+		return true
+	}
+
+	sender.SendMessage(fmt.Sprintf("Connecting you to %s...", target))
+	go func() {
+		if err := session.ConnectTo(target); err != nil {
+			sender.SendMessage(err.Error())
+		}
+	}()
+	return true
+}
@@ -0,0 +1,433 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// The configuration written out when no configuration file exists yet.
+const defaultConfig = `# go-pe-proxy configuration
+
+# Address the proxy listens on for MCPE clients.
+listen = ":19132"
+
+# Shown in the server list. Must not contain semicolons. {online} and {max}
+# are replaced with the player count and limit.
+motd = "A go-pe-proxy server"
+
+# Maximum number of players allowed on the proxy. Players joining a full proxy
+# are disconnected with full_message, unless they have the proxy.join.full
+# permission or are on the full_bypass list.
+# bypass_slots extra connections are accepted beyond max_players so that they
+# can get as far as logging in.
+max_players = 100
+full_message = "The network is full. Please try again later."
+full_bypass = []
+bypass_slots = 5
+
+# Where permission groups and the players in them are stored. See the README
+# for the format.
+permissions_file = "permissions.json"
+
+# Where bans are stored.
+ban_file = "bans.json"
+
+# Where the whitelist is stored, and the message shown to players that aren't
+# on it while it is on.
+whitelist_file = "whitelist.json"
+whitelist_message = "You are not whitelisted on this network."
+
+# Pass players' real addresses on to the servers behind the proxy, in the
+# login packet's server address field. See the README for the format. Only
+# turn this on if your servers understand it.
+#
+# With a forwarding secret, the forwarded address, username, UUID and server
+# name are signed, so servers can check that a login really came through the
+# proxy.
+# Use a long random string, and give the same one to your servers.
+ip_forward = false
+forwarding_secret = ""
+
+# The protocol number and version shown in the server list.
+protocol = 38
+version = "0.13.0"
+
+# The player count shown in the server list: "proxy" counts the players on
+# this proxy, "network" adds up the players reported by every server that is
+# up (this needs health checks).
+player_count = "proxy"
+
+# How long the server list entry is cached for. "0s" turns caching off.
+pong_cache = "1s"
+
+# Server (or group) players are sent to after logging in.
+default_server = "lobby"
+
+# Servers (or groups) tried, in order, when a player can't connect to the
+# server they were sent to.
+priorities = ["lobby"]
+
+# What happens to players on a server that is removed from this file when the
+# configuration is reloaded: "move" sends them to the default server, "kick"
+# disconnects them. Either way, they are shown the message below.
+removed_server_action = "move"
+removed_server_message = "The server you were on has been removed."
+
+# Players kicked from a server are moved to the fallback server and shown the
+# kick message, instead of being disconnected. Moves can be limited to kicks
+# from certain servers, or to kick messages containing one of the reasons.
+# Leave fallback empty to always disconnect kicked players.
+[kick]
+fallback = "lobby"
+servers = []
+reasons = []
+
+# Limits on new sessions from a single address. join_interval is the minimum
+# time between new sessions ("0s" turns it off), max_per_ip the maximum number
+# of sessions at once (0 turns it off). Addresses in the same /cidr_prefix
+# network count as one. Refused clients are either ignored ("drop") or told
+# that the proxy is full ("reply").
+#
+# With handshake_cookie on, clients have to echo a cookie sent in our first
+# handshake reply before a session is created for them, so floods from spoofed
+# addresses cost nothing. Some older or third party clients don't support this.
+[throttle]
+join_interval = "1s"
+max_per_ip = 3
+cidr_prefix = 32
+reject = "drop"
+handshake_cookie = false
+
+# Servers are pinged regularly. Players are never sent to a server that is
+# down. A server is marked down after "fall" failed pings in a row, and up
+# again after "rise" successful ones.
+[health_check]
+enabled = true
+interval = "5s"
+timeout = "2s"
+rise = 2
+fall = 3
+
+# An HTTP API for managing the proxy from other programs. Keep it on a local
+# address: requests are only checked against the token, which clients send in
+# an "Authorization: Bearer <token>" header.
+[admin_api]
+enabled = false
+listen = "127.0.0.1:8080"
+token = ""
+
+# Serves Prometheus metrics on http://<listen>/metrics: traffic to and from
+# players and servers, resent and lost datagrams, batch compression, sessions,
+# players per server and how long servers take to let players in.
+[metrics]
+enabled = false
+listen = "127.0.0.1:9100"
+
+# Each server may set handshake_timeout, which defaults to "5s", and weight,
+# which defaults to 1. Restricted servers (restricted = true) only let in
+# players with the proxy.server.<name> permission.
+[servers.lobby]
+address = "127.0.0.1:19134"
+
+# Groups balance players across several servers. Anywhere a server name can be
+# used, a group name can be used too. The strategy is one of round-robin,
+# least-players, lowest-latency or random (which takes weights into account).
+# Servers that are down or full are skipped.
+#[groups.lobbies]
+#servers = ["lobby-1", "lobby-2"]
+#strategy = "round-robin"
+
+# Forced hosts send players to a server based on the address they typed in.
+# The MOTD is optional and is shown to clients pinging an address the host
+# name resolves to.
+#[forced_hosts."minigames.example.net"]
+#server = "minigames"
+#motd = "Minigames!"
+`
+
+const (
+	REMOVED_SERVER_MOVE = "move"
+	REMOVED_SERVER_KICK = "kick"
+)
+
+type Config struct {
+	Listen               string                       `toml:"listen"`
+	Motd                 string                       `toml:"motd"`
+	MaxPlayers           int                          `toml:"max_players"`
+	FullMessage          string                       `toml:"full_message"`
+	FullBypass           []string                     `toml:"full_bypass"`
+	BypassSlots          int                          `toml:"bypass_slots"`
+	PermissionsFile      string                       `toml:"permissions_file"`
+	BanFile              string                       `toml:"ban_file"`
+	WhitelistFile        string                       `toml:"whitelist_file"`
+	WhitelistMessage     string                       `toml:"whitelist_message"`
+	IPForward            bool                         `toml:"ip_forward"`
+	ForwardingSecret     string                       `toml:"forwarding_secret"`
+	Protocol             int                          `toml:"protocol"`
+	Version              string                       `toml:"version"`
+	PlayerCount          string                       `toml:"player_count"`
+	PongCache            string                       `toml:"pong_cache"`
+	DefaultServer        string                       `toml:"default_server"`
+	Priorities           []string                     `toml:"priorities"`
+	RemovedServerAction  string                       `toml:"removed_server_action"`
+	RemovedServerMessage string                       `toml:"removed_server_message"`
+	Servers              map[string]ServerConfig      `toml:"servers"`
+	Groups               map[string]ServerGroupConfig `toml:"groups"`
+	ForcedHosts          map[string]ForcedHostConfig  `toml:"forced_hosts"`
+	Kick                 KickConfig                   `toml:"kick"`
+	HealthCheck          HealthCheckConfig            `toml:"health_check"`
+	Throttle             ThrottleConfig               `toml:"throttle"`
+	AdminApi             AdminApiConfig               `toml:"admin_api"`
+	Metrics              MetricsConfig                `toml:"metrics"`
+
+	// The file this configuration was loaded from, used when reloading.
+	path      string
+	pongCache time.Duration
+}
+
+type ServerConfig struct {
+	Address          string `toml:"address"`
+	HandshakeTimeout string `toml:"handshake_timeout"`
+	Weight           *int   `toml:"weight"`
+	// Only players with the proxy.server.<name> permission may join.
+	Restricted bool `toml:"restricted"`
+}
+
+type KickConfig struct {
+	Fallback string   `toml:"fallback"`
+	Servers  []string `toml:"servers"`
+	Reasons  []string `toml:"reasons"`
+}
+
+type HealthCheckConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Interval string `toml:"interval"`
+	Timeout  string `toml:"timeout"`
+	Rise     int    `toml:"rise"`
+	Fall     int    `toml:"fall"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// Loads the configuration file at the given path. If the file doesn't exist,
+// a default configuration is written there first.
+func LoadConfig(path string) (config *Config, err error) {
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		if err = ioutil.WriteFile(path, []byte(defaultConfig), 0644); err != nil {
+			return
+		}
+	}
+
+	config = &Config{
+		MaxPlayers:           100,
+		FullMessage:          "The network is full. Please try again later.",
+		PermissionsFile:      "permissions.json",
+		BanFile:              "bans.json",
+		WhitelistFile:        "whitelist.json",
+		WhitelistMessage:     "You are not whitelisted on this network.",
+		Protocol:             38,
+		Version:              "0.13.0",
+		PlayerCount:          PLAYER_COUNT_PROXY,
+		PongCache:            "1s",
+		RemovedServerAction:  REMOVED_SERVER_MOVE,
+		RemovedServerMessage: "The server you were on has been removed.",
+		Throttle: ThrottleConfig{
+			JoinInterval: "1s",
+			MaxPerIP:     3,
+			CIDRPrefix:   32,
+			Reject:       THROTTLE_REJECT_DROP,
+		},
+		AdminApi: AdminApiConfig{
+			Listen: "127.0.0.1:8080",
+		},
+		Metrics: MetricsConfig{
+			Listen: "127.0.0.1:9100",
+		},
+		HealthCheck: HealthCheckConfig{
+			Enabled:  true,
+			Interval: "5s",
+			Timeout:  "2s",
+			Rise:     2,
+			Fall:     3,
+		},
+		path: path,
+	}
+	if _, err = toml.DecodeFile(path, config); err != nil {
+		return nil, err
+	}
+
+	if err = config.validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *Config) validate() error {
+	if this.Listen == "" {
+		return errors.New("No listen address specified.")
+	}
+	if strings.Contains(this.Motd, ";") {
+		return errors.New("The MOTD may not contain semicolons.")
+	}
+	if this.MaxPlayers <= 0 {
+		return errors.New("max_players must be at least 1.")
+	}
+	if this.BypassSlots < 0 {
+		return errors.New("bypass_slots may not be negative.")
+	}
+	if strings.Contains(this.Version, ";") {
+		return errors.New("The version may not contain semicolons.")
+	}
+	if this.PlayerCount != PLAYER_COUNT_PROXY && this.PlayerCount != PLAYER_COUNT_NETWORK {
+		return fmt.Errorf("Unknown player_count %q.", this.PlayerCount)
+	}
+	var err error
+	if this.pongCache, err = time.ParseDuration(this.PongCache); err != nil || this.pongCache < 0 {
+		return fmt.Errorf("Invalid pong_cache %q.", this.PongCache)
+	}
+	if len(this.Servers) == 0 {
+		return errors.New("No servers are defined.")
+	}
+	for name, sc := range this.Servers {
+		if sc.Weight != nil && *sc.Weight < 0 {
+			return fmt.Errorf("The weight of server %s may not be negative.", name)
+		}
+	}
+	for name, group := range this.Groups {
+		if _, ok := this.Servers[name]; ok {
+			return fmt.Errorf("Group %s has the same name as a server.", name)
+		}
+		if len(group.Servers) == 0 {
+			return fmt.Errorf("Group %s has no servers.", name)
+		}
+		for _, member := range group.Servers {
+			if _, ok := this.Servers[member]; !ok {
+				return fmt.Errorf("Server %q in group %s is not defined.", member, name)
+			}
+		}
+		if !isValidStrategy(group.Strategy) {
+			return fmt.Errorf("Unknown strategy %q for group %s.", group.Strategy, name)
+		}
+	}
+	if !this.isTarget(this.DefaultServer) {
+		return fmt.Errorf("Default server %q is not defined.", this.DefaultServer)
+	}
+	for _, name := range this.Priorities {
+		if !this.isTarget(name) {
+			return fmt.Errorf("Priority server %q is not defined.", name)
+		}
+	}
+	if this.Kick.Fallback != "" && !this.isTarget(this.Kick.Fallback) {
+		return fmt.Errorf("Kick fallback server %q is not defined.", this.Kick.Fallback)
+	}
+	for host, fh := range this.ForcedHosts {
+		if !this.isTarget(fh.Server) {
+			return fmt.Errorf("Server %q for forced host %s is not defined.", fh.Server, host)
+		}
+		if strings.Contains(fh.Motd, ";") {
+			return fmt.Errorf("The MOTD for forced host %s may not contain semicolons.", host)
+		}
+	}
+	if err := this.HealthCheck.validate(); err != nil {
+		return err
+	}
+	if err := this.Throttle.validate(); err != nil {
+		return err
+	}
+	if this.AdminApi.Enabled && this.AdminApi.Token == "" {
+		return errors.New("The admin API is enabled, but has no token.")
+	}
+	if this.ForwardingSecret != "" && !this.IPForward {
+		return errors.New("forwarding_secret is set, but ip_forward is off.")
+	}
+	if this.RemovedServerAction != REMOVED_SERVER_MOVE && this.RemovedServerAction != REMOVED_SERVER_KICK {
+		return fmt.Errorf("Unknown removed_server_action %q.", this.RemovedServerAction)
+	}
+	return nil
+}
+
+func (this *HealthCheckConfig) validate() (err error) {
+	if this.interval, err = time.ParseDuration(this.Interval); err != nil || this.interval <= 0 {
+		return fmt.Errorf("Invalid health check interval %q.", this.Interval)
+	}
+	if this.timeout, err = time.ParseDuration(this.Timeout); err != nil || this.timeout <= 0 {
+		return fmt.Errorf("Invalid health check timeout %q.", this.Timeout)
+	}
+	if this.Rise < 1 || this.Fall < 1 {
+		return errors.New("Health check rise and fall must be at least 1.")
+	}
+	return nil
+}
+
+func (this *ThrottleConfig) validate() (err error) {
+	if this.joinInterval, err = time.ParseDuration(this.JoinInterval); err != nil || this.joinInterval < 0 {
+		return fmt.Errorf("Invalid throttle join_interval %q.", this.JoinInterval)
+	}
+	if this.MaxPerIP < 0 {
+		return errors.New("Throttle max_per_ip may not be negative.")
+	}
+	if this.CIDRPrefix < 1 || this.CIDRPrefix > 32 {
+		return errors.New("Throttle cidr_prefix must be between 1 and 32.")
+	}
+	if this.Reject != THROTTLE_REJECT_DROP && this.Reject != THROTTLE_REJECT_REPLY {
+		return fmt.Errorf("Unknown throttle reject %q.", this.Reject)
+	}
+	return nil
+}
+
+// Whether players can be sent to the given name, which is either a server or
+// a group.
+func (this *Config) isTarget(name string) bool {
+	if _, ok := this.Servers[name]; ok {
+		return true
+	}
+	_, ok := this.Groups[name]
+	return ok
+}
+
+// Resolves the listen address.
+func (this *Config) ListenAddress() (*net.UDPAddr, error) {
+	return net.ResolveUDPAddr("udp4", this.Listen)
+}
+
+// Resolves all configured servers.
+func (this *Config) buildServers() (map[string]*Server, error) {
+	servers := make(map[string]*Server)
+	for name, sc := range this.Servers {
+		addr, err := net.ResolveUDPAddr("udp4", sc.Address)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve address for server %s: %s", name, err.Error())
+		}
+		timeout := 5 * time.Second
+		if sc.HandshakeTimeout != "" {
+			if timeout, err = time.ParseDuration(sc.HandshakeTimeout); err != nil {
+				return nil, fmt.Errorf("Invalid handshake timeout for server %s: %s", name, err.Error())
+			}
+		}
+		servers[name] = NewServer(name, addr, timeout)
+		if sc.Weight != nil {
+			servers[name].Weight = *sc.Weight
+		}
+		servers[name].Restricted = sc.Restricted
+	}
+	return servers, nil
+}
+
+func (this *Config) buildGroups(servers map[string]*Server) map[string]*ServerGroup {
+	groups := make(map[string]*ServerGroup)
+	for name, gc := range this.Groups {
+		members := make([]*Server, 0, len(gc.Servers))
+		for _, member := range gc.Servers {
+			members = append(members, servers[member])
+		}
+		groups[name] = NewServerGroup(name, members, gc.Strategy)
+	}
+	return groups
+}
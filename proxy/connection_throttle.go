@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	THROTTLE_REJECT_DROP  = "drop"
+	THROTTLE_REJECT_REPLY = "reply"
+)
+
+type ThrottleConfig struct {
+	JoinInterval string `toml:"join_interval"`
+	MaxPerIP     int    `toml:"max_per_ip"`
+	CIDRPrefix   int    `toml:"cidr_prefix"`
+	Reject       string `toml:"reject"`
+	// Whether clients have to echo a cookie during the handshake.
+	HandshakeCookie bool `toml:"handshake_cookie"`
+
+	joinInterval time.Duration
+}
+
+// Limits how quickly, and how many, sessions can be created from one address.
+type ConnectionThrottle struct {
+	// Counters come first so they are aligned for atomic access.
+	accepted  uint64
+	throttled uint64
+	limited   uint64
+
+	sync.Mutex
+	// INTERNAL: when each network last created a session
+	lastJoin  map[string]time.Time
+	lastPrune time.Time
+}
+
+// Counters for monitoring.
+type ThrottleStats struct {
+	// Sessions that were let through.
+	Accepted uint64
+	// Sessions refused because the address joined too recently.
+	Throttled uint64
+	// Sessions refused because the address has too many sessions already.
+	Limited uint64
+}
+
+func NewConnectionThrottle() (this *ConnectionThrottle) {
+	this = new(ConnectionThrottle)
+	this.lastJoin = make(map[string]time.Time)
+	this.lastPrune = time.Now()
+	return
+}
+
+// Returns the network an address is grouped into.
+func (this *ThrottleConfig) network(ip net.IP) *net.IPNet {
+	ip = ip.To4()
+	mask := net.CIDRMask(this.CIDRPrefix, 32)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// Decides whether a new session may be created for an address, and records
+// it if so.
+func (this *ConnectionThrottle) Allow(proxy *Proxy, ip net.IP) bool {
+	config := proxy.Config().Throttle
+	if ip.To4() == nil {
+		// Everything we do is IPv4.
+		atomic.AddUint64(&this.accepted, 1)
+		return true
+	}
+
+	network := config.network(ip)
+
+	if config.MaxPerIP > 0 && proxy.Registry.CountInNetwork(network) >= config.MaxPerIP {
+		atomic.AddUint64(&this.limited, 1)
+		return false
+	}
+
+	if config.joinInterval > 0 {
+		key := network.String()
+		now := time.Now()
+
+		this.Lock()
+		if last, ok := this.lastJoin[key]; ok && now.Sub(last) < config.joinInterval {
+			this.Unlock()
+			atomic.AddUint64(&this.throttled, 1)
+			return false
+		}
+		this.lastJoin[key] = now
+		this.prune(now, config.joinInterval)
+		this.Unlock()
+	}
+
+	atomic.AddUint64(&this.accepted, 1)
+	return true
+}
+
+// Forgets about networks that can join again. Must be called with the lock
+// held.
+func (this *ConnectionThrottle) prune(now time.Time, interval time.Duration) {
+	if now.Sub(this.lastPrune) < time.Minute {
+		return
+	}
+	this.lastPrune = now
+	for key, last := range this.lastJoin {
+		if now.Sub(last) >= interval {
+			delete(this.lastJoin, key)
+		}
+	}
+}
+
+func (this *ConnectionThrottle) Stats() ThrottleStats {
+	return ThrottleStats{
+		Accepted:  atomic.LoadUint64(&this.accepted),
+		Throttled: atomic.LoadUint64(&this.throttled),
+		Limited:   atomic.LoadUint64(&this.limited),
+	}
+}
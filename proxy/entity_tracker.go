@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"../packets/mcpe"
+	"bytes"
+	"encoding/binary"
+	"github.com/pborman/uuid"
+	"sync"
+)
+
+// Keeps track of what a server has spawned on a client, so that it can all be
+// removed again when the player switches servers. The client would otherwise
+// keep showing entities and players from the old server.
+type EntityTracker struct {
+	sync.Mutex
+	// INTERNAL: entity IDs as the client knows them
+	entities map[int64]struct{}
+	players  map[int64]uuid.UUID
+	// INTERNAL: player list entries, by UUID
+	playerList map[string]uuid.UUID
+}
+
+func NewEntityTracker() (this *EntityTracker) {
+	this = new(EntityTracker)
+	this.reset()
+	return
+}
+
+func (this *EntityTracker) reset() {
+	this.entities = make(map[int64]struct{})
+	this.players = make(map[int64]uuid.UUID)
+	this.playerList = make(map[string]uuid.UUID)
+}
+
+func readEntityId(pkt []byte, offset int) (int64, bool) {
+	if offset+8 > len(pkt) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(pkt[offset:])), true
+}
+
+// Looks at a packet on its way to the client, after its entity IDs have been
+// rewritten.
+func (this *EntityTracker) Track(pkt []byte) {
+	if len(pkt) == 0 {
+		return
+	}
+
+	switch pkt[0] {
+	case mcpe.ID_MCPE_ADD_PLAYER:
+		offsets := addPlayerIds(pkt)
+		if len(offsets) == 0 {
+			return
+		}
+		if eid, ok := readEntityId(pkt, offsets[0]); ok {
+			this.Lock()
+			this.players[eid] = uuid.UUID(append([]byte(nil), pkt[1:17]...))
+			this.Unlock()
+		}
+	case mcpe.ID_MCPE_REMOVE_PLAYER:
+		if eid, ok := readEntityId(pkt, 1); ok {
+			this.Lock()
+			delete(this.players, eid)
+			this.Unlock()
+		}
+	case mcpe.ID_MCPE_ADD_ENTITY, mcpe.ID_MCPE_ADD_ITEM_ENTITY, mcpe.ID_MCPE_ADD_PAINTING:
+		if eid, ok := readEntityId(pkt, 1); ok {
+			this.Lock()
+			this.entities[eid] = struct{}{}
+			this.Unlock()
+		}
+	case mcpe.ID_MCPE_REMOVE_ENTITY:
+		if eid, ok := readEntityId(pkt, 1); ok {
+			this.Lock()
+			delete(this.entities, eid)
+			this.Unlock()
+		}
+	case mcpe.ID_MCPE_PLAYER_LIST:
+		list := new(mcpe.MCPEPlayerList)
+		if err := list.Decode(bytes.NewReader(pkt[1:])); err != nil {
+			return
+		}
+		this.Lock()
+		for _, player := range list.Players {
+			if list.Action == mcpe.PlayerListAdd {
+				this.playerList[player.UUID.String()] = player.UUID
+			} else {
+				delete(this.playerList, player.UUID.String())
+			}
+		}
+		this.Unlock()
+	}
+}
+
+// Returns a batch removing everything that has been spawned so far, or nil if
+// there is nothing to remove, and forgets about all of it.
+func (this *EntityTracker) Despawn() (batch *mcpe.MCPEBatch, err error) {
+	this.Lock()
+	defer this.Unlock()
+
+	batch = new(mcpe.MCPEBatch)
+	for eid, id := range this.players {
+		if err = batch.AddPacket(mcpe.MCPERemovePlayer{eid, id}); err != nil {
+			return nil, err
+		}
+	}
+	for eid := range this.entities {
+		if err = batch.AddPacket(mcpe.MCPERemoveEntity{eid}); err != nil {
+			return nil, err
+		}
+	}
+	if len(this.playerList) > 0 {
+		list := mcpe.MCPEPlayerList{Action: mcpe.PlayerListRemove}
+		for _, id := range this.playerList {
+			list.Players = append(list.Players, mcpe.MCPEPlayerListPlayer{UUID: id})
+		}
+		if err = batch.AddPacket(list); err != nil {
+			return nil, err
+		}
+	}
+	this.reset()
+
+	if len(batch.Payload) == 0 {
+		return nil, nil
+	}
+	return batch, nil
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"strings"
+)
+
+type ForcedHostConfig struct {
+	Server string `toml:"server"`
+	Motd   string `toml:"motd"`
+}
+
+// Forced hosts, resolved from the configuration.
+type forcedHosts struct {
+	// Server names, by the host name players typed in.
+	servers map[string]string
+	// MOTDs, by the local IP address the host name resolves to.
+	motds map[string]string
+}
+
+func (this *Config) buildForcedHosts() (hosts forcedHosts) {
+	hosts.servers = make(map[string]string)
+	hosts.motds = make(map[string]string)
+
+	// Host names that share an address with another forced host can't be told
+	// apart when we get a ping, as the ping doesn't carry the host name.
+	ambiguous := make(map[string]bool)
+
+	for host, fh := range this.ForcedHosts {
+		host = normalizeHost(host)
+		hosts.servers[host] = fh.Server
+
+		if fh.Motd == "" {
+			continue
+		}
+
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			log.Printf("Unable to resolve forced host %s, its MOTD will not be used: %s", host, err.Error())
+			continue
+		}
+		for _, ip := range ips {
+			key := ip.String()
+			if _, ok := hosts.motds[key]; ok {
+				ambiguous[key] = true
+			}
+			hosts.motds[key] = fh.Motd
+		}
+	}
+
+	for key := range ambiguous {
+		log.Printf("More than one forced host resolves to %s, the default MOTD will be used for it.", key)
+		delete(hosts.motds, key)
+	}
+	return
+}
+
+// Strips the port (if any) and trailing dot from a host name.
+func normalizeHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
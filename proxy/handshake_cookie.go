@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// How long a cookie stays valid for. Cookies from the previous window are
+// accepted too, so a cookie lives for between one and two windows.
+const cookieWindow = 30 * time.Second
+
+// Stateless cookies for the offline handshake. The first reply carries a
+// cookie derived from the client's address, which the client has to echo in
+// its second request before we create a session. Clients sending from a
+// spoofed address never see the cookie, so they can't make us allocate
+// anything.
+type HandshakeCookies struct {
+	// Number of second requests dropped for a missing or wrong cookie.
+	rejected uint64
+
+	secret []byte
+}
+
+func NewHandshakeCookies() (this *HandshakeCookies) {
+	this = new(HandshakeCookies)
+	this.secret = make([]byte, 32)
+	if _, err := rand.Read(this.secret); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (this *HandshakeCookies) generate(endpoint *net.UDPAddr, window int64) uint32 {
+	mac := hmac.New(sha256.New, this.secret)
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(window))
+	mac.Write(buf)
+	mac.Write(endpoint.IP.To16())
+	binary.BigEndian.PutUint16(buf, uint16(endpoint.Port))
+	mac.Write(buf[:2])
+
+	// Set the top bit so the cookie can never be mistaken for the start of an
+	// address when the second request is decoded.
+	return binary.BigEndian.Uint32(mac.Sum(nil)) | 0x80000000
+}
+
+// Returns the cookie to send to an endpoint.
+func (this *HandshakeCookies) Issue(endpoint *net.UDPAddr) uint32 {
+	return this.generate(endpoint, time.Now().UnixNano()/int64(cookieWindow))
+}
+
+// Checks a cookie echoed by an endpoint. Cookies are never 0, so a request
+// without one can be checked as 0.
+func (this *HandshakeCookies) Verify(endpoint *net.UDPAddr, cookie uint32) bool {
+	window := time.Now().UnixNano() / int64(cookieWindow)
+	if cookie == this.generate(endpoint, window) || cookie == this.generate(endpoint, window-1) {
+		return true
+	}
+	atomic.AddUint64(&this.rejected, 1)
+	return false
+}
+
+// Returns the number of second requests dropped for a bad cookie.
+func (this *HandshakeCookies) Rejected() uint64 {
+	return atomic.LoadUint64(&this.rejected)
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"../packets/raknet"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Periodically pings every configured server to find out whether it is up.
+type HealthChecker struct {
+	proxy *Proxy
+
+	// INTERNAL: channel used to stop the checker goroutine
+	poison chan struct{}
+}
+
+func NewHealthChecker(proxy *Proxy) (this *HealthChecker) {
+	this = new(HealthChecker)
+	this.proxy = proxy
+	this.poison = make(chan struct{}, 1)
+	return
+}
+
+func (this *HealthChecker) Run() {
+	// The interval may change on reload, so don't use a ticker.
+	for {
+		this.checkAll()
+
+		select {
+		case <-this.poison:
+			return
+		case <-time.After(this.proxy.Config().HealthCheck.interval):
+		}
+	}
+}
+
+func (this *HealthChecker) Stop() {
+	select {
+	case this.poison <- struct{}{}:
+	default:
+	}
+}
+
+func (this *HealthChecker) checkAll() {
+	config := this.proxy.Config().HealthCheck
+	for _, server := range this.proxy.Servers() {
+		go func(server *Server) {
+			status, err := PingServer(server.Address, config.timeout)
+			if server.recordCheck(status, config.Rise, config.Fall) {
+				if server.IsOnline() {
+					log.Printf("Server %s is up.", server.Name)
+				} else {
+					log.Printf("Server %s is down: %s", server.Name, err.Error())
+				}
+			}
+		}(server)
+	}
+}
+
+// Sends an unconnected ping to a server and parses the reply.
+func PingServer(address *net.UDPAddr, timeout time.Duration) (*ServerStatus, error) {
+	conn, err := net.DialUDP("udp4", nil, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	sent := time.Now()
+	ping := raknet.RakNetUnconnectedPing{PingId: raknet.GetTimeMilliseconds()}
+	if err = raknet.WriteUDPPreConnected(conn, ping); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		read, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if read == 0 || buf[0] != raknet.ID_UNCONNECTED_PONG {
+			continue
+		}
+
+		pong := new(raknet.RakNetUnconnectedPong)
+		if err = pong.Decode(bytes.NewReader(buf[1:read])); err != nil {
+			return nil, err
+		}
+		if pong.PingId != ping.PingId {
+			continue
+		}
+
+		status, err := parseMotd(pong.Name)
+		if err != nil {
+			return nil, err
+		}
+		status.Latency = time.Since(sent)
+		status.LastCheck = time.Now()
+		return status, nil
+	}
+}
+
+// Parses a MOTD in the MCPE;name;protocol;version;online;max format.
+func parseMotd(motd string) (*ServerStatus, error) {
+	parts := strings.Split(motd, ";")
+	if len(parts) < 6 || parts[0] != "MCPE" {
+		return nil, errors.New("Server sent an invalid MOTD")
+	}
+
+	protocol, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, errors.New("Server sent an invalid protocol version")
+	}
+	online, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return nil, errors.New("Server sent an invalid player count")
+	}
+	max, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return nil, errors.New("Server sent an invalid player limit")
+	}
+
+	return &ServerStatus{
+		Online:     true,
+		Motd:       parts[1],
+		Protocol:   protocol,
+		Version:    parts[3],
+		Players:    online,
+		MaxPlayers: max,
+	}, nil
+}
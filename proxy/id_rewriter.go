@@ -3,10 +3,16 @@ package proxy
 import (
 	"../packets/mcpe"
 	"encoding/binary"
+	"sync/atomic"
 )
 
+// Players keep the entity ID the first server gave them for as long as they
+// are on the proxy, since the client can't be told about a new one. Other
+// servers will use a different ID for the player, so the two are swapped in
+// every packet that carries an entity ID.
 type EntityIdRewriter struct {
 	clientId int64
+	// Changes when the player switches servers, so only accessed atomically.
 	serverId int64
 }
 
@@ -15,31 +21,147 @@ func NewEntityIdRewriter(initialId int64) EntityIdRewriter {
 }
 
 func (rw *EntityIdRewriter) SetNewServerId(id int64) {
-	rw.serverId = id
+	atomic.StoreInt64(&rw.serverId, id)
 }
 
-// Rewrites a slice of bytes for packets we don't want to process.
-func (rw EntityIdRewriter) RewriteBytes(packet []byte) []byte {
-	switch packet[0] {
-	case mcpe.ID_MCPE_ANIMATE:
-		i := binary.BigEndian.Uint64(packet[1:])
-		if i == uint64(rw.serverId) {
-			binary.BigEndian.PutUint64(packet[1:], uint64(rw.clientId))
+// A copy to rewrite with, which won't change under us if the player switches
+// servers in the meantime.
+func (rw *EntityIdRewriter) current() EntityIdRewriter {
+	return EntityIdRewriter{rw.clientId, atomic.LoadInt64(&rw.serverId)}
+}
+
+// Whether there is anything to rewrite at all. There isn't while the player
+// is on their first server.
+func (rw EntityIdRewriter) changesIds() bool {
+	return rw.clientId != rw.serverId
+}
+
+// Finds the offsets of the entity IDs in a packet, counting its ID byte.
+type entityIdLocator func(pkt []byte) []int
+
+// For packets with entity IDs at fixed offsets.
+func at(offsets ...int) entityIdLocator {
+	return func([]byte) []int {
+		return offsets
+	}
+}
+
+// For packets with an int count followed by entries of the given size, each
+// starting with an entity ID.
+func everyEntry(size int) entityIdLocator {
+	return func(pkt []byte) (offsets []int) {
+		if len(pkt) < 5 {
+			return
 		}
-		return packet
+		count := int(int32(binary.BigEndian.Uint32(pkt[1:])))
+		for i := 0; i < count && 5+i*size+8 <= len(pkt); i++ {
+			offsets = append(offsets, 5+i*size)
+		}
+		return
 	}
-	return packet
 }
 
-// Rewrites entity IDs for packets we are processing.
-func (rw EntityIdRewriter) RewritePacket(packet interface{}) interface{} {
-	switch pkt := packet.(type) {
-	case mcpe.MCPEStartGame:
-		if pkt.EntityId == rw.serverId {
-			pkt.EntityId = rw.clientId
+// AddPlayer has the player's UUID and username before the entity ID.
+func addPlayerIds(pkt []byte) []int {
+	if len(pkt) < 19 {
+		return nil
+	}
+	return []int{19 + int(binary.BigEndian.Uint16(pkt[17:]))}
+}
+
+// Only additions to the player list carry entity IDs, each after a UUID and
+// followed by a username and skin.
+func playerListIds(pkt []byte) (offsets []int) {
+	if len(pkt) < 6 || mcpe.PlayerListAction(pkt[1]) != mcpe.PlayerListAdd {
+		return
+	}
+	count := int(int32(binary.BigEndian.Uint32(pkt[2:])))
+	pos := 6
+	for i := 0; i < count; i++ {
+		pos += 16
+		if pos+10 > len(pkt) {
+			return
+		}
+		offsets = append(offsets, pos)
+		pos += 8
+		pos += 2 + int(binary.BigEndian.Uint16(pkt[pos:]))
+		// Skin: alpha, slim, then the data.
+		pos += 2
+		if pos+2 > len(pkt) {
+			return
 		}
-		return pkt
-	default:
-		return pkt
+		pos += 2 + int(binary.BigEndian.Uint16(pkt[pos:]))
 	}
+	return
+}
+
+// Packets from servers that carry entity IDs.
+var clientboundEntityIds = map[byte]entityIdLocator{
+	mcpe.ID_MCPE_START_GAME:          at(14),
+	mcpe.ID_MCPE_ADD_PLAYER:          addPlayerIds,
+	mcpe.ID_MCPE_REMOVE_PLAYER:       at(1),
+	mcpe.ID_MCPE_ADD_ENTITY:          at(1),
+	mcpe.ID_MCPE_REMOVE_ENTITY:       at(1),
+	mcpe.ID_MCPE_ADD_ITEM_ENTITY:     at(1),
+	mcpe.ID_MCPE_TAKE_ITEM_ENTITY:    at(1, 9),
+	mcpe.ID_MCPE_MOVE_ENTITY:         everyEntry(8 + 6*4),
+	mcpe.ID_MCPE_MOVE_PLAYER:         at(1),
+	mcpe.ID_MCPE_ADD_PAINTING:        at(1),
+	mcpe.ID_MCPE_ENTITY_EVENT:        at(1),
+	mcpe.ID_MCPE_MOB_EFFECT:          at(1),
+	mcpe.ID_MCPE_UPDATE_ATTRIBUTES:   at(1),
+	mcpe.ID_MCPE_MOB_EQUIPMENT:       at(1),
+	mcpe.ID_MCPE_MOB_ARMOR_EQUIPMENT: at(1),
+	mcpe.ID_MCPE_INTERACT:            at(2),
+	mcpe.ID_MCPE_SET_ENTITY_DATA:     at(1),
+	mcpe.ID_MCPE_SET_ENTITY_MOTION:   everyEntry(8 + 3*4),
+	mcpe.ID_MCPE_SET_ENTITY_LINK:     at(1, 9),
+	mcpe.ID_MCPE_ANIMATE:             at(2),
+	mcpe.ID_MCPE_PLAYER_LIST:         playerListIds,
+}
+
+// Packets from clients that carry entity IDs.
+var serverboundEntityIds = map[byte]entityIdLocator{
+	mcpe.ID_MCPE_MOVE_PLAYER:         at(1),
+	mcpe.ID_MCPE_ENTITY_EVENT:        at(1),
+	mcpe.ID_MCPE_MOB_EQUIPMENT:       at(1),
+	mcpe.ID_MCPE_MOB_ARMOR_EQUIPMENT: at(1),
+	mcpe.ID_MCPE_INTERACT:            at(2),
+	mcpe.ID_MCPE_PLAYER_ACTION:       at(1),
+	mcpe.ID_MCPE_ANIMATE:             at(2),
+}
+
+// Rewrites a packet from the server, in place.
+func (rw EntityIdRewriter) RewriteClientbound(packet []byte) []byte {
+	return rw.rewrite(clientboundEntityIds, packet)
+}
+
+// Rewrites a packet from the client, in place.
+func (rw EntityIdRewriter) RewriteServerbound(packet []byte) []byte {
+	return rw.rewrite(serverboundEntityIds, packet)
+}
+
+func (rw EntityIdRewriter) rewrite(table map[byte]entityIdLocator, packet []byte) []byte {
+	if !rw.changesIds() || len(packet) == 0 {
+		return packet
+	}
+	locate, ok := table[packet[0]]
+	if !ok {
+		return packet
+	}
+
+	for _, offset := range locate(packet) {
+		if offset+8 > len(packet) {
+			continue
+		}
+		// Swapping, rather than just replacing, keeps entities on the new
+		// server that happen to have the player's old ID apart from the player.
+		switch int64(binary.BigEndian.Uint64(packet[offset:])) {
+		case rw.serverId:
+			binary.BigEndian.PutUint64(packet[offset:], uint64(rw.clientId))
+		case rw.clientId:
+			binary.BigEndian.PutUint64(packet[offset:], uint64(rw.serverId))
+		}
+	}
+	return packet
 }
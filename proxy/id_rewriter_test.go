@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"../packets/mcpe"
+	"../packets/raknet"
+	"bytes"
+	"github.com/pborman/uuid"
+	"testing"
+)
+
+const (
+	// The ID the first server gave the player, which the client keeps using.
+	clientId int64 = 1
+	// The player's ID on the server they are on now.
+	serverId int64 = 77
+	// Some other entity, which must be left alone.
+	otherId int64 = 500
+)
+
+type rewriteCase struct {
+	name string
+	// Whether the packet is sent by clients, servers, or both.
+	serverbound bool
+	clientbound bool
+	// Builds the packet with the player's entity ID set to id.
+	build func(id int64) []byte
+}
+
+// Writes packet fields one after another.
+type rawPacket struct {
+	bytes.Buffer
+}
+
+func newRawPacket(id byte) *rawPacket {
+	p := new(rawPacket)
+	p.WriteByte(id)
+	return p
+}
+
+func (p *rawPacket) long(v int64) *rawPacket {
+	raknet.WriteInt64(p, v)
+	return p
+}
+
+func (p *rawPacket) int(v int32) *rawPacket {
+	raknet.WriteInt32(p, v)
+	return p
+}
+
+func (p *rawPacket) byte(v byte) *rawPacket {
+	p.WriteByte(v)
+	return p
+}
+
+func (p *rawPacket) floats(n int) *rawPacket {
+	for i := 0; i < n; i++ {
+		mcpe.WriteFloat32(p, 1.5)
+	}
+	return p
+}
+
+func (p *rawPacket) string(v string) *rawPacket {
+	raknet.WriteString(p, v)
+	return p
+}
+
+func encodePacket(pkt raknet.EncodablePacket) []byte {
+	buf := new(bytes.Buffer)
+	if err := pkt.Encode(buf); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// Packets where the entity ID is the first field.
+func leading(name string, id byte, serverbound bool) rewriteCase {
+	return rewriteCase{name, serverbound, true, func(eid int64) []byte {
+		return newRawPacket(id).long(eid).int(3).floats(3).Bytes()
+	}}
+}
+
+var playerUuid = uuid.NewRandom()
+
+var rewriteCases = []rewriteCase{
+	{"StartGame", false, true, func(eid int64) []byte {
+		return encodePacket(mcpe.MCPEStartGame{Seed: 5, Dimension: 1, Generator: 1, Gamemode: 1, EntityId: eid})
+	}},
+	{"AddPlayer", false, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_ADD_PLAYER).long(7).long(7).string("Steve").long(eid).floats(9).Bytes()
+	}},
+	leading("RemovePlayer", mcpe.ID_MCPE_REMOVE_PLAYER, false),
+	leading("AddEntity", mcpe.ID_MCPE_ADD_ENTITY, false),
+	leading("RemoveEntity", mcpe.ID_MCPE_REMOVE_ENTITY, false),
+	leading("AddItemEntity", mcpe.ID_MCPE_ADD_ITEM_ENTITY, false),
+	{"TakeItemEntity", false, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_TAKE_ITEM_ENTITY).long(otherId).long(eid).Bytes()
+	}},
+	{"MoveEntity", false, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_MOVE_ENTITY).int(3).
+			long(otherId).floats(6).
+			long(eid).floats(6).
+			long(otherId + 1).floats(6).Bytes()
+	}},
+	leading("MovePlayer", mcpe.ID_MCPE_MOVE_PLAYER, true),
+	leading("AddPainting", mcpe.ID_MCPE_ADD_PAINTING, false),
+	leading("EntityEvent", mcpe.ID_MCPE_ENTITY_EVENT, true),
+	leading("MobEffect", mcpe.ID_MCPE_MOB_EFFECT, false),
+	leading("UpdateAttributes", mcpe.ID_MCPE_UPDATE_ATTRIBUTES, false),
+	leading("MobEquipment", mcpe.ID_MCPE_MOB_EQUIPMENT, true),
+	leading("MobArmorEquipment", mcpe.ID_MCPE_MOB_ARMOR_EQUIPMENT, true),
+	{"Interact", true, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_INTERACT).byte(2).long(eid).Bytes()
+	}},
+	{"PlayerAction", true, false, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_PLAYER_ACTION).long(eid).int(5).int(0).int(64).int(0).int(1).Bytes()
+	}},
+	leading("SetEntityData", mcpe.ID_MCPE_SET_ENTITY_DATA, false),
+	{"SetEntityMotion", false, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_SET_ENTITY_MOTION).int(2).
+			long(eid).floats(3).
+			long(otherId).floats(3).Bytes()
+	}},
+	{"SetEntityLink", false, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_SET_ENTITY_LINK).long(otherId).long(eid).byte(1).Bytes()
+	}},
+	{"Animate", true, true, func(eid int64) []byte {
+		return newRawPacket(mcpe.ID_MCPE_ANIMATE).byte(1).long(eid).Bytes()
+	}},
+	{"PlayerList", false, true, func(eid int64) []byte {
+		return encodePacket(mcpe.MCPEPlayerList{mcpe.PlayerListAdd, []mcpe.MCPEPlayerListPlayer{
+			{uuid.NewSHA1(playerUuid, []byte("other")), otherId, "Alex", mcpe.Skin{Data: make([]byte, 16)}},
+			{playerUuid, eid, "Steve", mcpe.Skin{Slim: true, Data: make([]byte, 32)}},
+		}})
+	}},
+}
+
+// Checks that entity IDs are rewritten in every packet type that carries
+// them, in both directions.
+func TestEntityIdRewriter(t *testing.T) {
+	rw := NewEntityIdRewriter(clientId)
+	rw.SetNewServerId(serverId)
+
+	for _, c := range rewriteCases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.clientbound {
+				// The player's ID on the server becomes the one the client
+				// knows, and the other way around.
+				checkRewrite(t, "clientbound", rw.RewriteClientbound, c.build(serverId), c.build(clientId))
+				checkRewrite(t, "clientbound, swapped", rw.RewriteClientbound, c.build(clientId), c.build(serverId))
+				checkRewrite(t, "clientbound, other", rw.RewriteClientbound, c.build(otherId+2), c.build(otherId+2))
+			} else {
+				checkRewrite(t, "clientbound, ignored", rw.RewriteClientbound, c.build(serverId), c.build(serverId))
+			}
+			if c.serverbound {
+				checkRewrite(t, "serverbound", rw.RewriteServerbound, c.build(clientId), c.build(serverId))
+				checkRewrite(t, "serverbound, other", rw.RewriteServerbound, c.build(otherId+2), c.build(otherId+2))
+			} else {
+				checkRewrite(t, "serverbound, ignored", rw.RewriteServerbound, c.build(clientId), c.build(clientId))
+			}
+		})
+	}
+}
+
+// Nothing is touched while the player is on their first server.
+func TestEntityIdRewriterFirstServer(t *testing.T) {
+	rw := NewEntityIdRewriter(clientId)
+	for _, c := range rewriteCases {
+		t.Run(c.name, func(t *testing.T) {
+			checkRewrite(t, "clientbound", rw.RewriteClientbound, c.build(clientId), c.build(clientId))
+			checkRewrite(t, "serverbound", rw.RewriteServerbound, c.build(clientId), c.build(clientId))
+		})
+	}
+}
+
+// Truncated packets must not crash the rewriter.
+func TestEntityIdRewriterTruncated(t *testing.T) {
+	rw := NewEntityIdRewriter(clientId)
+	rw.SetNewServerId(serverId)
+	for _, c := range rewriteCases {
+		t.Run(c.name, func(t *testing.T) {
+			full := c.build(serverId)
+			for i := 0; i < len(full); i++ {
+				rw.RewriteClientbound(append([]byte(nil), full[:i]...))
+				rw.RewriteServerbound(append([]byte(nil), full[:i]...))
+			}
+		})
+	}
+}
+
+func checkRewrite(t *testing.T, what string, rewrite func([]byte) []byte, in []byte, want []byte) {
+	t.Helper()
+	got := rewrite(append([]byte(nil), in...))
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s:\n  got  %x\n  want %x", what, got, want)
+	}
+}
@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Saves v as indented JSON. It is written to a temporary file first, so a
+// crash can't leave us with half a file.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
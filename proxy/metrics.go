@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"../metrics"
+	"log"
+	"net/http"
+	"time"
+)
+
+type MetricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+}
+
+// Peers and directions packets are counted by.
+const (
+	PEER_CLIENT   = "client"
+	PEER_SERVER   = "server"
+	DIRECTION_IN  = "in"
+	DIRECTION_OUT = "out"
+)
+
+var (
+	packetsTotal = metrics.NewCounterVec("proxy_packets_total",
+		"UDP packets, by whether they were to or from players (client) or servers (server).", "peer", "direction")
+	bytesTotal = metrics.NewCounterVec("proxy_bytes_total",
+		"Bytes in UDP packets, by whether they were to or from players (client) or servers (server).", "peer", "direction")
+	handshakeSeconds = metrics.NewHistogramVec("proxy_handshake_seconds",
+		"How long servers took to accept a player, from the first handshake packet to StartGame.",
+		metrics.ExponentialBuckets(0.005, 2, 12), "server")
+	handshakeFailures = metrics.NewCounterVec("proxy_handshake_failures_total",
+		"Connections to servers that failed or timed out before StartGame.", "server")
+)
+
+func init() {
+	// So they show up as 0 before any traffic.
+	for _, peer := range []string{PEER_CLIENT, PEER_SERVER} {
+		for _, direction := range []string{DIRECTION_IN, DIRECTION_OUT} {
+			packetsTotal.With(peer, direction)
+			bytesTotal.With(peer, direction)
+		}
+	}
+}
+
+func countPacket(peer string, direction string, size int) {
+	packetsTotal.With(peer, direction).Inc()
+	bytesTotal.With(peer, direction).Add(uint64(size))
+}
+
+// Gauges read from the proxy's state when scraped.
+func (this *Proxy) gauges() []metrics.Collector {
+	sessions := metrics.NewGaugeFunc("proxy_sessions", "Sessions, by state.", "state", func() map[string]float64 {
+		counts := map[string]float64{
+			STATE_IDENTIFY.String():   0,
+			STATE_CONNECTING.String(): 0,
+			STATE_CONNECTED.String():  0,
+		}
+		for _, session := range this.Registry.All() {
+			counts[session.state.String()]++
+		}
+		return counts
+	})
+	players := metrics.NewGaugeFunc("proxy_server_players", "Players on each server through this proxy.", "server", func() map[string]float64 {
+		counts := make(map[string]float64)
+		for _, server := range this.Servers() {
+			counts[server.Name] = 0
+		}
+		for _, session := range this.Registry.Players() {
+			if server := session.CurrentServer(); server != nil {
+				counts[server.Name]++
+			}
+		}
+		return counts
+	})
+	return []metrics.Collector{sessions, players}
+}
+
+func (this *Proxy) newMetricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(append([]metrics.Collector{metrics.Default}, this.gauges()...)...))
+	return &http.Server{
+		Addr:         this.Config().Metrics.Listen,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+func (this *Proxy) serveMetrics() {
+	log.Printf("Serving metrics on http://%s/metrics", this.metricsServer.Addr)
+	if err := this.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Unable to serve metrics: %s", err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PLAYER_COUNT_PROXY   = "proxy"
+	PLAYER_COUNT_NETWORK = "network"
+)
+
+// Caches the server list entry, so floods of pings don't each have to count
+// players.
+type pongCache struct {
+	sync.Mutex
+	entries map[string]cachedPong
+}
+
+type cachedPong struct {
+	name    string
+	expires time.Time
+}
+
+func newPongCache() *pongCache {
+	return &pongCache{entries: make(map[string]cachedPong)}
+}
+
+func (this *pongCache) clear() {
+	this.Lock()
+	this.entries = make(map[string]cachedPong)
+	this.Unlock()
+}
+
+// Returns the server list entry sent in reply to unconnected pings that were
+// sent to the given local address (which may be nil).
+func (this *Proxy) PongName(local net.IP) string {
+	config := this.Config()
+
+	key := ""
+	if local != nil {
+		key = local.String()
+	}
+
+	if config.pongCache > 0 {
+		this.pongCache.Lock()
+		defer this.pongCache.Unlock()
+		if entry, ok := this.pongCache.entries[key]; ok && time.Now().Before(entry.expires) {
+			return entry.name
+		}
+	}
+
+	online := this.OnlinePlayers()
+	max := config.MaxPlayers
+	motd := strings.NewReplacer(
+		"{online}", strconv.Itoa(online),
+		"{max}", strconv.Itoa(max),
+	).Replace(this.MotdFor(local))
+	name := fmt.Sprintf("MCPE;%s;%d;%s;%d;%d", motd, config.Protocol, config.Version, online, max)
+
+	if config.pongCache > 0 {
+		this.pongCache.entries[key] = cachedPong{name, time.Now().Add(config.pongCache)}
+	}
+	return name
+}
+
+// Returns the number of players shown in the server list: either the players
+// logged in to this proxy, or the players on every server that is up.
+func (this *Proxy) OnlinePlayers() int {
+	if this.Config().PlayerCount != PLAYER_COUNT_NETWORK {
+		return this.Registry.Len()
+	}
+
+	total := 0
+	for _, server := range this.Servers() {
+		if status := server.Status(); status.Online {
+			total += status.Players
+		}
+	}
+	return total
+}
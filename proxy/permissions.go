@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Every player is in this group, on top of the groups they are given.
+const DEFAULT_GROUP = "default"
+
+// Groups of permission nodes and the players in them, persisted to a JSON
+// file. A node starting with "-" takes a permission away, and a node ending in
+// ".*" covers every node starting with it.
+type Permissions struct {
+	sync.RWMutex
+	path string
+	data permissionsData
+}
+
+type permissionsData struct {
+	Groups map[string]*permissionGroup `json:"groups"`
+	// By lower case username or by UUID.
+	Players map[string]*permissionPlayer `json:"players"`
+}
+
+type permissionGroup struct {
+	// Groups whose permissions this group has too, unless it overrides them.
+	Inherits    []string `json:"inherits,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+type permissionPlayer struct {
+	Groups []string `json:"groups,omitempty"`
+	// Overrides for this player, which win over their groups.
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// What a new permissions file starts out with: everyone may see the commands
+// and switch servers.
+var defaultPermissions = permissionsData{
+	Groups: map[string]*permissionGroup{
+		DEFAULT_GROUP: {Permissions: []string{"proxy.command.help", "proxy.command.server"}},
+	},
+	Players: map[string]*permissionPlayer{},
+}
+
+// Loads permissions from a file. If the file doesn't exist, it is created
+// with the default group.
+func LoadPermissions(path string) (this *Permissions, err error) {
+	this = new(Permissions)
+	this.path = path
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		this.data = defaultPermissions
+		if err = this.save(); err != nil {
+			return nil, err
+		}
+	}
+	if err = this.Reload(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Re-reads permissions from their file.
+func (this *Permissions) Reload() error {
+	var data permissionsData
+	raw, err := ioutil.ReadFile(this.path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("Unable to parse %s: %s", this.path, err.Error())
+	}
+
+	this.Lock()
+	this.data = data
+	this.Unlock()
+	return nil
+}
+
+// Must be called with the lock held.
+func (this *Permissions) save() error {
+	return writeJSONFile(this.path, this.data)
+}
+
+// Whether a player has a permission node. The player's own overrides are
+// checked first, then their groups in order (each before the groups it
+// inherits from), then the default group. The id may be nil.
+func (this *Permissions) Has(username string, id uuid.UUID, node string) bool {
+	this.RLock()
+	defer this.RUnlock()
+
+	var lists [][]string
+	var groups []string
+	keys := []string{strings.ToLower(username)}
+	if id != nil {
+		keys = append([]string{id.String()}, keys...)
+	}
+	for _, key := range keys {
+		if player := this.data.Players[key]; player != nil {
+			lists = append(lists, player.Permissions)
+			groups = append(groups, player.Groups...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, group := range append(groups, DEFAULT_GROUP) {
+		lists = this.groupPermissions(group, seen, lists)
+	}
+
+	for _, list := range lists {
+		if allowed, found := matchPermission(list, node); found {
+			return allowed
+		}
+	}
+	return false
+}
+
+// Appends a group's permissions, and those of the groups it inherits from, to
+// lists. Must be called with the lock held.
+func (this *Permissions) groupPermissions(name string, seen map[string]bool, lists [][]string) [][]string {
+	// Also guards against groups inheriting from each other.
+	if seen[name] {
+		return lists
+	}
+	seen[name] = true
+
+	group := this.data.Groups[name]
+	if group == nil {
+		return lists
+	}
+	lists = append(lists, group.Permissions)
+	for _, parent := range group.Inherits {
+		lists = this.groupPermissions(parent, seen, lists)
+	}
+	return lists
+}
+
+// Finds the most specific entry in a list that covers a node. An exact match
+// beats a wildcard, and taking a permission away beats granting it.
+func matchPermission(list []string, node string) (allowed bool, found bool) {
+	best := -1
+	for _, entry := range list {
+		negated := strings.HasPrefix(entry, "-")
+		granted := strings.TrimPrefix(entry, "-")
+		if !permissionMatches(granted, node) {
+			continue
+		}
+
+		specificity := len(granted) - 1
+		if granted == node {
+			specificity = len(node) + 1
+		}
+		if specificity > best || (specificity == best && negated) {
+			best = specificity
+			allowed = !negated
+			found = true
+		}
+	}
+	return
+}
+
+// Whether a granted node covers the one asked for. "proxy.command.*" covers
+// every command, and "*" covers everything.
+func permissionMatches(granted string, node string) bool {
+	if granted == "*" || granted == node {
+		return true
+	}
+	return strings.HasSuffix(granted, ".*") && strings.HasPrefix(node, granted[:len(granted)-1])
+}
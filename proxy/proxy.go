@@ -1,73 +1,521 @@
 package proxy
 
 import (
+	"../packets/raknet"
+	"bytes"
+	"errors"
+	"github.com/pborman/uuid"
+	"golang.org/x/net/ipv4"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"runtime"
+	"strings"
+	"sync"
 )
 
 type Proxy struct {
-	Registry *SessionRegistry
+	Registry  *SessionRegistry
+	Bans      *BanList
+	Whitelist *Whitelist
+	Throttle  *ConnectionThrottle
+	Cookies   *HandshakeCookies
+	Commands  *CommandRegistry
+	// Permission groups, and the players in them.
+	Permissions *Permissions
 
 	address *net.UDPAddr
-	conn    *net.UDPConn
-	guid    int64
+	// Set once by ListenAndServe, and left alone after that.
+	conn *net.UDPConn
+	guid int64
+	// INTERNAL: closed by Close
+	closed    chan struct{}
+	closeOnce sync.Once
 
 	unknownSession *unknownSession
-	servers        map[string]*Server
+	healthChecker  *HealthChecker
+	adminApi       *AdminApi
+	metricsServer  *http.Server
+	pongCache      *pongCache
+
+	// Everything below is swapped out on reload and must be accessed with
+	// configLock held.
+	configLock  sync.RWMutex
+	config      *Config
+	servers     map[string]*Server
+	groups      map[string]*ServerGroup
+	forcedHosts forcedHosts
 }
 
-func NewProxy(address *net.UDPAddr) (this *Proxy) {
+func NewProxy(config *Config) (this *Proxy, err error) {
+	address, err := config.ListenAddress()
+	if err != nil {
+		return nil, err
+	}
+	servers, err := config.buildServers()
+	if err != nil {
+		return nil, err
+	}
+
+	bans, err := LoadBanList(config.BanFile)
+	if err != nil {
+		return nil, err
+	}
+
+	whitelist, err := LoadWhitelist(config.WhitelistFile)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := LoadPermissions(config.PermissionsFile)
+	if err != nil {
+		return nil, err
+	}
+
 	this = new(Proxy)
 	this.Registry = NewSessionRegistry()
+	this.Bans = bans
+	this.Whitelist = whitelist
+	this.Permissions = permissions
+	this.Throttle = NewConnectionThrottle()
+	this.Cookies = NewHandshakeCookies()
+	this.Commands = NewCommandRegistry()
 	this.address = address
 	this.unknownSession = NewUnknownSession(this)
-	this.servers = make(map[string]*Server)
+	this.healthChecker = NewHealthChecker(this)
+	this.pongCache = newPongCache()
+	this.config = config
+	this.servers = servers
+	this.groups = config.buildGroups(servers)
+	this.forcedHosts = config.buildForcedHosts()
 	this.guid = rand.Int63()
+	this.closed = make(chan struct{})
+	this.registerCommands()
+	this.adminApi = NewAdminApi(this)
+	this.metricsServer = this.newMetricsServer()
+	return
+}
+
+// Returns the currently active configuration. It must not be modified.
+func (this *Proxy) Config() *Config {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	return this.config
+}
+
+// Returns a snapshot of all configured servers.
+func (this *Proxy) Servers() (servers []*Server) {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	servers = make([]*Server, 0, len(this.servers))
+	for _, server := range this.servers {
+		servers = append(servers, server)
+	}
+	return
+}
+
+func (this *Proxy) GetServer(name string) *Server {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	return this.servers[name]
+}
+
+// Returns the group with the given name, if there is one.
+func (this *Proxy) GetGroup(name string) *ServerGroup {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	return this.groups[name]
+}
+
+// Returns the servers a server or group name stands for, best choice first.
+// Group members that are down or full are left out.
+func (this *Proxy) Resolve(target string) []*Server {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	return this.resolve(target)
+}
+
+func (this *Proxy) resolve(target string) []*Server {
+	if server, ok := this.servers[target]; ok {
+		return []*Server{server}
+	}
+	if group, ok := this.groups[target]; ok {
+		return group.Order()
+	}
+	return nil
+}
+
+// Returns the servers to try, in order, when sending a player to the given
+// server or group: the target itself, followed by the priority servers.
+func (this *Proxy) ConnectionChain(target string) []*Server {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+
+	var chain []*Server
+	seen := make(map[*Server]bool)
+	for _, name := range append([]string{target}, this.config.Priorities...) {
+		for _, server := range this.resolve(name) {
+			if !seen[server] {
+				seen[server] = true
+				chain = append(chain, server)
+			}
+		}
+	}
+	return chain
+}
+
+// Returns the server or group a player kicked from the given server should be
+// moved to, or an empty string if they should be disconnected.
+func (this *Proxy) KickFallback(from *Server, reason string) string {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+
+	policy := this.config.Kick
+	if policy.Fallback == "" || policy.Fallback == from.Name {
+		return ""
+	}
+	if len(policy.Servers) > 0 && !containsString(policy.Servers, from.Name) {
+		return ""
+	}
+	if len(policy.Reasons) > 0 {
+		matched := false
+		lower := strings.ToLower(reason)
+		for _, r := range policy.Reasons {
+			if strings.Contains(lower, strings.ToLower(r)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return ""
+		}
+	}
+	return policy.Fallback
+}
+
+// Returns the server or group a player should be sent to, based on the
+// address they typed in to connect.
+func (this *Proxy) ServerForHost(address string) string {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	if name, ok := this.forcedHosts.servers[normalizeHost(address)]; ok {
+		return name
+	}
+	return this.config.DefaultServer
+}
+
+// Adds a ban and disconnects everyone it applies to. Returns the number of
+// players that were disconnected.
+func (this *Proxy) Ban(ban *Ban) (int, error) {
+	if err := this.Bans.Add(ban); err != nil {
+		return 0, err
+	}
+
+	kicked := 0
+	for _, session := range this.Registry.All() {
+		if ban.Applies(session) && session.AbandonWithReason(ban.Message()) {
+			kicked++
+		}
+	}
+	return kicked, nil
+}
+
+// Disconnects every player the whitelist doesn't allow. Returns the number of
+// players that were disconnected.
+func (this *Proxy) EnforceWhitelist() int {
+	msg := this.Config().WhitelistMessage
+	kicked := 0
+	for _, session := range this.Registry.All() {
+		if session.username == nil {
+			continue
+		}
+		var id uuid.UUID
+		if session.uuid != nil {
+			id = *session.uuid
+		}
+		if !this.CanJoinWhitelisted(*session.username, id) && session.AbandonWithReason(msg) {
+			kicked++
+		}
+	}
+	return kicked
+}
+
+// Whether the given player may join when the proxy is full.
+func (this *Proxy) CanJoinWhenFull(username string, id uuid.UUID) bool {
+	for _, name := range this.Config().FullBypass {
+		if strings.EqualFold(name, username) {
+			return true
+		}
+	}
+	return this.Permissions.Has(username, id, "proxy.join.full")
+}
+
+// Whether the given player may join with the whitelist as it is.
+func (this *Proxy) CanJoinWhitelisted(username string, id uuid.UUID) bool {
+	return this.Whitelist.Allows(username, id) || this.Permissions.Has(username, id, "proxy.join.whitelist")
+}
+
+// Whether a player has a permission node. Players who haven't logged in yet
+// only have the default group's permissions.
+func (this *Proxy) HasPermission(session *Session, node string) bool {
+	if node == "" {
+		return true
+	}
+	var username string
+	var id uuid.UUID
+	if session.username != nil {
+		username = *session.username
+	}
+	if session.uuid != nil {
+		id = *session.uuid
+	}
+	return this.Permissions.Has(username, id, node)
+}
+
+// Returns the MOTD to show to a client that pinged the given local address.
+// The address may be nil if it isn't known.
+func (this *Proxy) MotdFor(local net.IP) string {
+	this.configLock.RLock()
+	defer this.configLock.RUnlock()
+	if local != nil {
+		if motd, ok := this.forcedHosts.motds[local.String()]; ok {
+			return motd
+		}
+	}
+	return this.config.Motd
+}
+
+// Re-reads the configuration file and swaps it in. Existing sessions keep
+// running; players on servers that no longer exist are moved to the default
+// server or kicked, depending on the configuration.
+func (this *Proxy) Reload() error {
+	config, err := LoadConfig(this.Config().path)
+	if err != nil {
+		return err
+	}
+	servers, err := config.buildServers()
+	if err != nil {
+		return err
+	}
+	groups := config.buildGroups(servers)
+	hosts := config.buildForcedHosts()
+
+	this.configLock.Lock()
+	if config.Listen != this.config.Listen {
+		log.Printf("The listen address can't be changed on reload, still listening on %s.", this.config.Listen)
+	}
+	if config.BanFile != this.config.BanFile {
+		log.Printf("The ban file can't be changed on reload, still using %s.", this.config.BanFile)
+		config.BanFile = this.config.BanFile
+	}
+	if config.WhitelistFile != this.config.WhitelistFile {
+		log.Printf("The whitelist file can't be changed on reload, still using %s.", this.config.WhitelistFile)
+		config.WhitelistFile = this.config.WhitelistFile
+	}
+	if config.PermissionsFile != this.config.PermissionsFile {
+		log.Printf("The permissions file can't be changed on reload, still using %s.", this.config.PermissionsFile)
+		config.PermissionsFile = this.config.PermissionsFile
+	}
+	if config.HealthCheck.Enabled != this.config.HealthCheck.Enabled {
+		log.Printf("Health checks can't be turned on or off on reload.")
+		config.HealthCheck.Enabled = this.config.HealthCheck.Enabled
+	}
+	if config.AdminApi.Enabled != this.config.AdminApi.Enabled || config.AdminApi.Listen != this.config.AdminApi.Listen {
+		log.Printf("The admin API can't be turned on or off or moved on reload, only its token changes.")
+		config.AdminApi.Enabled = this.config.AdminApi.Enabled
+		config.AdminApi.Listen = this.config.AdminApi.Listen
+	}
+	if config.Metrics != this.config.Metrics {
+		log.Printf("Metrics can't be turned on or off or moved on reload.")
+		config.Metrics = this.config.Metrics
+	}
+	for name, server := range servers {
+		if old, ok := this.servers[name]; ok && old.Address.String() == server.Address.String() {
+			server.inheritStatus(old)
+		}
+	}
+	this.config = config
+	this.servers = servers
+	this.groups = groups
+	this.forcedHosts = hosts
+	this.configLock.Unlock()
+	this.pongCache.clear()
+
+	if err = this.Bans.Reload(); err != nil {
+		log.Printf("Unable to reload bans: %s", err.Error())
+	}
+	if err = this.Whitelist.Reload(); err != nil {
+		log.Printf("Unable to reload the whitelist: %s", err.Error())
+	}
+	if err = this.Permissions.Reload(); err != nil {
+		log.Printf("Unable to reload permissions: %s", err.Error())
+	}
+	this.EnforceWhitelist()
+
+	log.Printf("Reloaded configuration, %d servers are defined.", len(servers))
+
+	// Deal with players on servers that were removed.
+	for _, session := range this.Registry.All() {
+		current := session.CurrentServer()
+		if current == nil || this.GetServer(current.Name) != nil {
+			continue
+		}
+
+		if config.RemovedServerAction == REMOVED_SERVER_MOVE {
+			log.Printf("%s was removed, moving %s to %s.", current.Name,
+				session.GetEndpointString(), config.DefaultServer)
+			session.SendMessage(config.RemovedServerMessage)
+			go func(session *Session) {
+				if err := session.ConnectAny(this.ConnectionChain(config.DefaultServer)); err != nil {
+					session.AbandonWithReason(config.RemovedServerMessage)
+				}
+			}(session)
+		} else {
+			log.Printf("%s was removed, kicking %s.", current.Name, session.GetEndpointString())
+			session.AbandonWithReason(config.RemovedServerMessage)
+		}
+	}
+
+	return nil
+}
+
+// Sends a chat message to every player. Returns how many players it was sent
+// to.
+func (this *Proxy) Broadcast(msg string) (sent int) {
+	for _, session := range this.Registry.Players() {
+		if session.SendMessage(msg) == nil {
+			sent++
+		}
+	}
 	return
 }
 
+// Disconnects every player with the given message, then stops the proxy.
+func (this *Proxy) Shutdown(reason string) {
+	for _, session := range this.Registry.All() {
+		session.AbandonWithReason(reason)
+	}
+	this.Close()
+}
+
 func (this *Proxy) Close() {
-	if this.conn != nil {
-		this.conn.Close()
-		this.conn = nil
+	this.closeOnce.Do(func() {
+		this.healthChecker.Stop()
+		this.adminApi.Close()
+		this.metricsServer.Close()
+		// ListenAndServe closes the listener when it sees this.
+		close(this.closed)
+	})
+}
+
+func (this *Proxy) isClosed() bool {
+	select {
+	case <-this.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sends a packet to a client, outside of any session.
+func (this *Proxy) sendTo(endpoint *net.UDPAddr, pkt raknet.EncodablePacket) error {
+	out := new(bytes.Buffer)
+	if err := pkt.Encode(out); err != nil {
+		return err
+	}
+	return this.sendDirect(endpoint, out.Bytes())
+}
+
+func (this *Proxy) sendDirect(endpoint *net.UDPAddr, pkt []byte) error {
+	// Sessions may still be sending after Close.
+	if this.isClosed() {
+		return errors.New("The proxy is closed.")
 	}
+	_, err := this.conn.WriteToUDP(pkt, endpoint)
+	if err == nil {
+		countPacket(PEER_CLIENT, DIRECTION_OUT, len(pkt))
+	}
+	return err
 }
 
 func (this *Proxy) ListenAndServe() {
-	conn, err := net.ListenUDP("udp", this.address)
+	conn, err := net.ListenUDP("udp4", this.address)
 	if err != nil {
 		log.Printf("Unable to bind to %s: %s", this.address.String(), err.Error())
 		return
 	}
 
+	// We need to know which of our addresses a packet was sent to, so forced
+	// hosts can have their own MOTD.
+	pc := ipv4.NewPacketConn(conn)
+	if err = pc.SetControlMessage(ipv4.FlagDst, true); err != nil {
+		log.Printf("Unable to get destination addresses, forced host MOTDs won't work: %s", err.Error())
+	}
+
+	if this.Config().HealthCheck.Enabled {
+		go this.healthChecker.Run()
+	}
+	if this.Config().AdminApi.Enabled {
+		go this.adminApi.ListenAndServe()
+	}
+	if this.Config().Metrics.Enabled {
+		go this.serveMetrics()
+	}
+
 	// Start some goroutines to handle "unknown session" packets
 	for i := 0; i < runtime.NumCPU(); i++ {
 		go this.unknownSession.Process()
 	}
 
 	this.conn = conn
+	go func() {
+		<-this.closed
+		conn.Close()
+	}()
 
 	// Begin serving clients in perpetuity.
 	for {
 		buf := make([]byte, 1500)
-		read, endpoint, err := conn.ReadFromUDP(buf)
+		read, cm, addr, err := pc.ReadFrom(buf)
 
 		if err != nil {
-			log.Println("Encountered an error while listening:", err)
+			// Close closes the listener, in which case this is expected.
+			if !this.isClosed() {
+				log.Println("Encountered an error while listening:", err)
+			}
 			return
 		}
 
+		endpoint := addr.(*net.UDPAddr)
+		countPacket(PEER_CLIENT, DIRECTION_IN, read)
+
 		// TODO: The performance of this will suck big-time. Find a more efficient
 		// replacement!
 		conn := this.Registry.GetByEndpoint(endpoint)
 
 		if conn != nil {
-			conn.processQueue <- buf[0:read]
+			select {
+			case conn.processQueue <- buf[0:read]:
+			default:
+				// The session has been abandoned, or isn't keeping up. It's
+				// UDP, so the client has to cope with lost packets anyway.
+			}
 		} else {
-			entry := unknownSessionEntry{buf[0:read], endpoint}
+			entry := unknownSessionEntry{buf[0:read], endpoint, nil}
+			if cm != nil {
+				entry.local = cm.Dst
+			}
 			this.unknownSession.processQueue <- entry
 		}
 	}
 }
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,847 @@
+package proxy
+
+import (
+	"../packets/mcpe"
+	"../packets/raknet"
+	"../util"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The proxy logs a lot, so only show it with -v.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+const relayConfig = `listen = "%s"
+max_players = 10
+ban_file = "%s"
+whitelist_file = "%s"
+permissions_file = "%s"
+default_server = "lobby"
+priorities = []
+
+[throttle]
+join_interval = "0s"
+max_per_ip = 0
+
+[health_check]
+enabled = false
+
+[admin_api]
+enabled = true
+listen = "%s"
+token = "relaytest"
+
+[metrics]
+enabled = true
+listen = "%s"
+
+[servers.lobby]
+address = "%s"
+
+[servers.games]
+address = "%s"
+
+[servers.vip]
+address = "%s"
+restricted = true
+`
+
+// The player's entity ID on the fake servers.
+const (
+	lobbyEntityId = 1234
+	gamesEntityId = 4321
+)
+
+// What the lobby spawns on the client.
+const (
+	lobbyMobId    = 5000
+	lobbyPlayerId = 5001
+)
+
+var lobbyPlayerUuid = uuid.NewRandom()
+
+// The chunk the lobby sends.
+const (
+	lobbyChunkX = 3
+	lobbyChunkZ = -4
+)
+
+// Where the player spawns on the second server, and in which gamemode.
+var gamesSpawn = mcpe.BlockCoordinates{10, 70, -5}
+
+const gamesGamemode = 1
+
+// One end of a RakNet connection, after the offline handshake. Datagrams it
+// receives are acked, like a real client or server would.
+type testPeer struct {
+	conn *net.UDPConn
+	// Where to send to, if conn isn't connected.
+	addr *net.UDPAddr
+
+	reliabilityNumber      util.AtomicInteger
+	datagramSequenceNumber util.AtomicInteger
+	splitPackets           raknet.SplitPacketHandler
+
+	// Set to stop acking datagrams.
+	silent int32
+
+	lock sync.Mutex
+	// Sequence numbers of the datagrams sent, and those the other end acked.
+	sent  map[int32]bool
+	acked map[int32]bool
+	// How often each datagram was received.
+	received map[int32]int
+}
+
+func newTestPeer(conn *net.UDPConn) *testPeer {
+	return &testPeer{
+		conn:         conn,
+		splitPackets: raknet.NewSplitPacketHandler(),
+		sent:         make(map[int32]bool),
+		acked:        make(map[int32]bool),
+		received:     make(map[int32]int),
+	}
+}
+
+func (this *testPeer) send(pkt raknet.EncodablePacket) (err error) {
+	buf := new(bytes.Buffer)
+	if err = pkt.Encode(buf); err != nil {
+		return
+	}
+	if this.addr != nil {
+		_, err = this.conn.WriteToUDP(buf.Bytes(), this.addr)
+	} else {
+		_, err = this.conn.Write(buf.Bytes())
+	}
+	return
+}
+
+// Sends a packet inside a datagram.
+func (this *testPeer) sendPackage(pkt raknet.EncodablePacket) error {
+	datagrams, err := raknet.CreateDatagrams(&this.reliabilityNumber, &this.datagramSequenceNumber, pkt, 1400)
+	if err != nil {
+		return err
+	}
+	for _, datagram := range *datagrams {
+		this.lock.Lock()
+		this.sent[datagram.DatagramSequenceNumber] = true
+		this.lock.Unlock()
+		if err = this.send(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the next UDP packet and returns the packets in it, with datagrams and
+// batches unpacked.
+func (this *testPeer) receive(timeout time.Duration) ([][]byte, error) {
+	buf := make([]byte, 2048)
+	this.conn.SetReadDeadline(time.Now().Add(timeout))
+	read, addr, err := this.conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+	if this.conn.RemoteAddr() == nil {
+		this.addr = addr
+	}
+
+	pktBytes := buf[:read]
+	switch pktBytes[0] {
+	case raknet.ID_DATA_4, raknet.ID_DATA_C:
+		datagram := new(raknet.RakNetDatagram)
+		if err = datagram.Decode(pktBytes); err != nil {
+			return nil, err
+		}
+		this.lock.Lock()
+		this.received[datagram.DatagramSequenceNumber]++
+		this.lock.Unlock()
+		if atomic.LoadInt32(&this.silent) == 0 {
+			ack := new(raknet.RakNetAck)
+			ack.Acknowledged = raknet.SliceAck([]int{int(datagram.DatagramSequenceNumber)})
+			this.send(ack)
+		}
+
+		var pkts [][]byte
+		for _, part := range datagram.Payload {
+			payload := part.Payload
+			if part.PartCount > 1 {
+				all := this.splitPackets.AcceptSplitPacket(*part)
+				if all == nil {
+					continue
+				}
+				var b bytes.Buffer
+				for _, p := range *all {
+					b.Write(p.Payload)
+				}
+				payload = b.Bytes()
+			}
+			inner, err := this.unpack(payload)
+			if err != nil {
+				return nil, err
+			}
+			pkts = append(pkts, inner...)
+		}
+		return pkts, nil
+	case raknet.ID_ACK:
+		ack := new(raknet.RakNetAck)
+		if err = ack.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+			return nil, err
+		}
+		this.lock.Lock()
+		for _, item := range ack.Acknowledged {
+			for id := item.Min; id <= item.Max; id++ {
+				this.acked[int32(id)] = true
+			}
+		}
+		this.lock.Unlock()
+		return nil, nil
+	case raknet.ID_NAK:
+		return nil, nil
+	}
+	return this.unpack(pktBytes)
+}
+
+// Takes packets out of a batch.
+func (this *testPeer) unpack(pktBytes []byte) ([][]byte, error) {
+	if len(pktBytes) > 0 && pktBytes[0] == mcpe.ID_MCPE_BATCH {
+		batch := new(mcpe.MCPEBatch)
+		if err := batch.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+			return nil, err
+		}
+		return batch.Payload, nil
+	}
+	return [][]byte{pktBytes}, nil
+}
+
+// Waits for a packet the check function accepts.
+func (this *testPeer) await(what string, check func(p []byte) bool) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pkts, err := this.receive(time.Until(deadline))
+		if err != nil {
+			break
+		}
+		for _, p := range pkts {
+			if len(p) > 0 && check(p) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("timed out waiting for %s", what)
+}
+
+func (this *testPeer) awaitText(text string) error {
+	return this.await(fmt.Sprintf("%q", text), func(p []byte) bool {
+		pkt := new(mcpe.MCPEText)
+		return p[0] == mcpe.ID_MCPE_TEXT && pkt.Decode(bytes.NewReader(p[1:])) == nil &&
+			strings.HasPrefix(pkt.Message, text)
+	})
+}
+
+func (this *testPeer) chat(msg string) error {
+	return this.sendPackage(mcpe.MCPEText{mcpe.TEXT_TYPE_CHAT, "Steve", msg})
+}
+
+// Packets that spawn a mob and another player, add that player to the player
+// list, and send a chunk.
+func spawnPackets() []raknet.EncodablePacket {
+	mob := new(bytes.Buffer)
+	raknet.WriteInt64(mob, lobbyMobId)
+	raknet.WriteInt32(mob, 10)
+	mob.Write(make([]byte, 6*4))
+
+	player := new(bytes.Buffer)
+	player.Write(lobbyPlayerUuid)
+	raknet.WriteString(player, "Alex")
+	raknet.WriteInt64(player, lobbyPlayerId)
+	player.Write(make([]byte, 9*4))
+
+	return []raknet.EncodablePacket{
+		raknet.GenericRakNetPackage{PacketId: mcpe.ID_MCPE_ADD_ENTITY, Payload: mob.Bytes()},
+		raknet.GenericRakNetPackage{PacketId: mcpe.ID_MCPE_ADD_PLAYER, Payload: player.Bytes()},
+		mcpe.MCPEPlayerList{mcpe.PlayerListAdd, []mcpe.MCPEPlayerListPlayer{
+			{lobbyPlayerUuid, lobbyPlayerId, "Alex", mcpe.Skin{Data: make([]byte, 64*32*4)}},
+		}},
+		mcpe.MCPEFullChunkData{lobbyChunkX, lobbyChunkZ, mcpe.CHUNK_ORDER_COLUMNS, bytes.Repeat([]byte{1}, 1000)},
+	}
+}
+
+// A server that accepts one player, sends StartGame and a welcome message,
+// and echoes chat back. The lobby also spawns a few things.
+type fakeServer struct {
+	*testPeer
+	name     string
+	entityId int64
+	// Everything the server is sent in chat.
+	chat chan string
+}
+
+func startFakeServer(t *testing.T, name string, entityId int64) *fakeServer {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	server := &fakeServer{newTestPeer(conn), name, entityId, make(chan string, 100)}
+	go server.serve()
+	return server
+}
+
+func (this *fakeServer) address() string {
+	return this.conn.LocalAddr().String()
+}
+
+func (this *fakeServer) serve() {
+	for {
+		pkts, err := this.receive(time.Minute)
+		if err != nil {
+			return
+		}
+
+		for _, p := range pkts {
+			switch p[0] {
+			case raknet.ID_OPEN_CONNECTION_REQUEST_1:
+				this.send(raknet.NewRakNetOpenConnectionReply1(42, 0, 1400))
+			case raknet.ID_OPEN_CONNECTION_REQUEST_2:
+				this.send(raknet.NewRakNetOpenConnectionReply2(42, *this.addr, 1400))
+			case raknet.ID_CONNECTION_REQUEST:
+				req := new(raknet.RakNetConnectionRequest)
+				req.Decode(bytes.NewReader(p[1:]))
+				this.sendPackage(raknet.RakNetConnectionRequestAccepted{
+					SystemAddress:     *this.addr,
+					IncomingTimestamp: req.Timestamp,
+					ServerTimestamp:   raknet.GetTimeMilliseconds(),
+				})
+			case mcpe.ID_MCPE_LOGIN:
+				// Everything after StartGame goes in the same batch, as that is
+				// what trips up a proxy that isn't careful.
+				batch := new(mcpe.MCPEBatch)
+				batch.AddPacket(mcpe.MCPEPlayerStatus{0})
+				startGame := mcpe.MCPEStartGame{EntityId: this.entityId}
+				if this.name == "games" {
+					startGame.Spawn = gamesSpawn
+					startGame.Gamemode = gamesGamemode
+				}
+				batch.AddPacket(startGame)
+				batch.AddPacket(mcpe.MCPEText{mcpe.TEXT_TYPE_RAW, "", "welcome to " + this.name})
+				if this.name == "lobby" {
+					for _, pkt := range spawnPackets() {
+						batch.AddPacket(pkt)
+					}
+				}
+				this.sendPackage(batch)
+			case mcpe.ID_MCPE_TEXT:
+				text := new(mcpe.MCPEText)
+				if err := text.Decode(bytes.NewReader(p[1:])); err != nil {
+					continue
+				}
+				select {
+				case this.chat <- text.Message:
+				default:
+				}
+				batch := new(mcpe.MCPEBatch)
+				batch.AddPacket(mcpe.MCPEText{mcpe.TEXT_TYPE_RAW, "", "echo: " + text.Message})
+				this.sendPackage(batch)
+			}
+		}
+	}
+}
+
+// Fails if the server was sent commands meant for the proxy.
+func (this *fakeServer) checkNoCommands(t *testing.T) {
+	t.Helper()
+	for {
+		select {
+		case msg := <-this.chat:
+			if strings.HasPrefix(msg, "/server") {
+				t.Errorf("%s was sent %q, which is meant for the proxy", this.name, msg)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// A proxy in front of two fake servers, lobby and games. vip is restricted
+// and points at games.
+type relayNetwork struct {
+	proxy         *Proxy
+	listen        string
+	apiListen     string
+	metricsListen string
+	lobby         *fakeServer
+	games         *fakeServer
+}
+
+// Returns a free local address. Someone could take it before we use it, but
+// that is unlikely enough for tests.
+func freeAddress(t *testing.T, network string) string {
+	if network == "udp4" {
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	l, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func startRelayNetwork(t *testing.T) *relayNetwork {
+	network := &relayNetwork{
+		listen:        freeAddress(t, "udp4"),
+		apiListen:     freeAddress(t, "tcp4"),
+		metricsListen: freeAddress(t, "tcp4"),
+		lobby:         startFakeServer(t, "lobby", lobbyEntityId),
+		games:         startFakeServer(t, "games", gamesEntityId),
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	contents := fmt.Sprintf(relayConfig, network.listen, filepath.Join(dir, "bans.json"),
+		filepath.Join(dir, "whitelist.json"), filepath.Join(dir, "permissions.json"), network.apiListen,
+		network.metricsListen, network.lobby.address(), network.games.address(), network.games.address())
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.proxy, err = NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go network.proxy.ListenAndServe()
+	t.Cleanup(network.proxy.Close)
+	time.Sleep(200 * time.Millisecond)
+	return network
+}
+
+// Connects a player to the proxy and logs them in, failing the test if they
+// don't make it to the lobby.
+func (this *relayNetwork) join(t *testing.T) *testPeer {
+	t.Helper()
+	client, err := this.tryJoin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.conn.Close() })
+	return client
+}
+
+func (this *relayNetwork) tryJoin() (*testPeer, error) {
+	target, err := net.ResolveUDPAddr("udp4", this.listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, target)
+	if err != nil {
+		return nil, err
+	}
+	client := newTestPeer(conn)
+
+	if err = client.send(raknet.RakNetOpenConnectionRequest1{7, 1400 - 32}); err != nil {
+		return nil, err
+	}
+	if err = client.await("the first handshake reply", func(p []byte) bool {
+		return p[0] == raknet.ID_OPEN_CONNECTION_REPLY_1
+	}); err != nil {
+		return nil, err
+	}
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if err = client.send(raknet.RakNetOpenConnectionRequest2{ClientEndpoint: local, MTU: 1400, GUID: 7}); err != nil {
+		return nil, err
+	}
+	if err = client.await("the second handshake reply", func(p []byte) bool {
+		return p[0] == raknet.ID_OPEN_CONNECTION_REPLY_2
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = client.sendPackage(raknet.RakNetConnectionRequest{GUID: 7, Timestamp: raknet.GetTimeMilliseconds()}); err != nil {
+		return nil, err
+	}
+	if err = client.await("the connection to be accepted", func(p []byte) bool {
+		return p[0] == raknet.ID_CONNECTION_REQUEST_ACCEPTED
+	}); err != nil {
+		return nil, err
+	}
+
+	client.sendPackage(raknet.RakNetNewIncomingConnection{Port: int16(local.Port)})
+	login := new(mcpe.MCPEBatch)
+	login.AddPacket(&mcpe.MCPELogin{
+		Username:      "Steve",
+		Protocol1:     38,
+		Protocol2:     38,
+		ClientGuid:    7,
+		ClientUuid:    uuid.NewRandom(),
+		ServerAddress: this.listen,
+		Skin:          &mcpe.Skin{Data: make([]byte, 64*32*4)},
+	})
+	if err = client.sendPackage(login); err != nil {
+		return nil, err
+	}
+
+	var startGame *mcpe.MCPEStartGame
+	if err = client.await("StartGame", func(p []byte) bool {
+		if p[0] != mcpe.ID_MCPE_START_GAME {
+			return false
+		}
+		startGame = new(mcpe.MCPEStartGame)
+		return startGame.Decode(bytes.NewReader(p[1:])) == nil
+	}); err != nil {
+		return nil, err
+	}
+	if startGame.EntityId != lobbyEntityId {
+		return nil, fmt.Errorf("StartGame has entity ID %d, not %d", startGame.EntityId, lobbyEntityId)
+	}
+	return client, nil
+}
+
+// Checks that everything the lobby spawned is removed and its world is
+// replaced, before the player is respawned in the new server's world.
+func awaitSwitch(t *testing.T, client *testPeer) {
+	t.Helper()
+	done := map[string]bool{}
+	if err := client.await("the player to be respawned", func(p []byte) bool {
+		switch p[0] {
+		case mcpe.ID_MCPE_REMOVE_ENTITY:
+			pkt := new(mcpe.MCPERemoveEntity)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.EntityId == lobbyMobId {
+				done["the lobby's mob was removed"] = true
+			}
+		case mcpe.ID_MCPE_REMOVE_PLAYER:
+			pkt := new(mcpe.MCPERemovePlayer)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.EntityId == lobbyPlayerId &&
+				uuid.Equal(pkt.ClientUuid, lobbyPlayerUuid) {
+				done["the lobby's player was removed"] = true
+			}
+		case mcpe.ID_MCPE_PLAYER_LIST:
+			pkt := new(mcpe.MCPEPlayerList)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.Action == mcpe.PlayerListRemove &&
+				len(pkt.Players) == 1 && uuid.Equal(pkt.Players[0].UUID, lobbyPlayerUuid) {
+				done["the lobby's player list entry was removed"] = true
+			}
+		case mcpe.ID_MCPE_FULL_CHUNK_DATA:
+			pkt := new(mcpe.MCPEFullChunkData)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.ChunkX == lobbyChunkX && pkt.ChunkZ == lobbyChunkZ &&
+				bytes.Equal(pkt.Data, make([]byte, len(pkt.Data))) {
+				done["the lobby's chunk was emptied"] = true
+			}
+		case mcpe.ID_MCPE_SET_SPAWN_POSITION:
+			pkt := new(mcpe.MCPESetSpawnPosition)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.Coordinates == gamesSpawn {
+				done["the spawn position was set"] = true
+			}
+		case mcpe.ID_MCPE_SET_PLAYER_GAMETYPE:
+			pkt := new(mcpe.MCPESetPlayerGametype)
+			if pkt.Decode(bytes.NewReader(p[1:])) == nil && pkt.Gamemode == gamesGamemode {
+				done["the gamemode was set"] = true
+			}
+		case mcpe.ID_MCPE_RESPAWN:
+			return true
+		}
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, what := range []string{
+		"the lobby's mob was removed",
+		"the lobby's player was removed",
+		"the lobby's player list entry was removed",
+		"the lobby's chunk was emptied",
+		"the spawn position was set",
+		"the gamemode was set",
+	} {
+		if !done[what] {
+			t.Errorf("the player was respawned before %s", what)
+		}
+	}
+	if err := client.awaitText("welcome to games"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Packets make it across in both directions, on their own and in batches,
+// including those sent right after StartGame.
+func TestRelay(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+
+	// Sent after StartGame, in the same batch.
+	if err := client.awaitText("welcome to lobby"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.chat("hello")
+	if err := client.awaitText("echo: hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := new(mcpe.MCPEBatch)
+	batch.AddPacket(mcpe.MCPEText{mcpe.TEXT_TYPE_CHAT, "Steve", "batched"})
+	client.sendPackage(batch)
+	if err := client.awaitText("echo: batched"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The proxy acks the datagrams the server sent, rather than made up ones.
+func TestServerAcks(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+
+	client.chat("hello")
+	if err := client.awaitText("echo: hello"); err != nil {
+		t.Fatal(err)
+	}
+	// Acks are sent every 50ms.
+	time.Sleep(200 * time.Millisecond)
+
+	lobby := network.lobby
+	lobby.lock.Lock()
+	defer lobby.lock.Unlock()
+	for id := range lobby.acked {
+		if !lobby.sent[id] {
+			t.Errorf("the proxy acked datagram %d, which the server never sent", id)
+		}
+	}
+	for id := range lobby.sent {
+		if !lobby.acked[id] {
+			t.Errorf("the proxy never acked datagram %d", id)
+		}
+	}
+}
+
+// Datagrams the server doesn't ack are sent again.
+func TestResendToServer(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+	if err := client.awaitText("welcome to lobby"); err != nil {
+		t.Fatal(err)
+	}
+
+	lobby := network.lobby
+	atomic.StoreInt32(&lobby.silent, 1)
+	client.chat("anyone there?")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		lobby.lock.Lock()
+		for _, count := range lobby.received {
+			if count > 1 {
+				lobby.lock.Unlock()
+				return
+			}
+		}
+		lobby.lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("the proxy didn't resend a datagram the server never acked")
+}
+
+// Switching servers removes what the old server spawned and replaces its
+// world before the player is respawned.
+func TestSwitchServers(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+
+	client.chat("/server games")
+	awaitSwitch(t, client)
+	network.lobby.checkNoCommands(t)
+}
+
+// The proxy answers its own commands and passes on the ones it doesn't know.
+func TestCommands(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+
+	client.chat("/server")
+	if err := client.awaitText("Servers: games, lobby"); err != nil {
+		t.Fatal(err)
+	}
+	client.chat("/help")
+	if err := client.awaitText("help [command]"); err != nil {
+		t.Fatal(err)
+	}
+	client.chat("/spawn")
+	if err := client.awaitText("echo: /spawn"); err != nil {
+		t.Fatal(err)
+	}
+	network.lobby.checkNoCommands(t)
+}
+
+// Players can't run commands or join servers they lack permission for.
+func TestPermissions(t *testing.T) {
+	network := startRelayNetwork(t)
+	network.proxy.Commands.Register(&Command{
+		Name:       "secret",
+		Usage:      "secret",
+		Permission: "relaytest.secret",
+		Handler: func(sender CommandSender, args []string) bool {
+			sender.SendMessage("You shouldn't be here.")
+			return true
+		},
+	})
+	client := network.join(t)
+
+	client.chat("/secret")
+	if err := client.awaitText("You don't have permission to use secret."); err != nil {
+		t.Fatal(err)
+	}
+	client.chat("/server vip")
+	if err := client.awaitText("Unable to connect to vip: You don't have permission to join it"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (this *relayNetwork) apiRequest(method string, path string, token string, body string, v interface{}) (int, error) {
+	req, err := http.NewRequest(method, "http://"+this.apiListen+path, strings.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode, err
+}
+
+// The admin API wants its token, can move the player, sees where they are,
+// and can broadcast to them.
+func TestAdminApi(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+
+	if status, err := network.apiRequest("GET", "/sessions", "wrong", "", nil); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("a wrong token got %d (%v), expected %d", status, err, http.StatusUnauthorized)
+	}
+
+	status, err := network.apiRequest("POST", "/send", "relaytest", `{"player": "Steve", "server": "games"}`, nil)
+	if err != nil || status != http.StatusAccepted {
+		t.Fatalf("sending the player to games got %d (%v)", status, err)
+	}
+	awaitSwitch(t, client)
+
+	var sessions []struct {
+		Username string `json:"username"`
+		Server   string `json:"server"`
+	}
+	if _, err := network.apiRequest("GET", "/sessions", "relaytest", "", &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Username != "Steve" || sessions[0].Server != "games" {
+		t.Errorf("listed sessions %+v, expected Steve on games", sessions)
+	}
+
+	var servers []struct {
+		Name    string `json:"name"`
+		Players int    `json:"players"`
+	}
+	if _, err := network.apiRequest("GET", "/servers", "relaytest", "", &servers); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		if server.Name == "games" && server.Players != 1 {
+			t.Errorf("counted %d players on games, expected 1", server.Players)
+		}
+	}
+
+	status, err = network.apiRequest("POST", "/broadcast", "relaytest", `{"message": "from the api"}`, nil)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("broadcasting got %d (%v)", status, err)
+	}
+	if err := client.awaitText("from the api"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Scrapes the metrics, by name and labels.
+func (this *relayNetwork) metrics(t *testing.T) map[string]string {
+	resp, err := http.Get("http://" + this.metricsListen + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if i := strings.LastIndex(line, " "); i > 0 && !strings.HasPrefix(line, "#") {
+			samples[line[:i]] = line[i+1:]
+		}
+	}
+	return samples
+}
+
+// The metrics know where the player is, how they got there, and that packets
+// went both ways. Counters are shared by every test, so they are only checked
+// for having moved.
+func TestMetrics(t *testing.T) {
+	network := startRelayNetwork(t)
+	client := network.join(t)
+	client.chat("/server games")
+	awaitSwitch(t, client)
+
+	samples := network.metrics(t)
+	for name, value := range map[string]string{
+		`proxy_server_players{server="games"}`: "1",
+		`proxy_server_players{server="lobby"}`: "0",
+		`proxy_sessions{state="connected"}`:    "1",
+	} {
+		if samples[name] != value {
+			t.Errorf("metric %s is %q, expected %s", name, samples[name], value)
+		}
+	}
+	for _, name := range []string{
+		`proxy_handshake_seconds_count{server="lobby"}`,
+		`proxy_handshake_seconds_count{server="games"}`,
+		`proxy_packets_total{peer="client",direction="in"}`,
+		`proxy_packets_total{peer="client",direction="out"}`,
+		`proxy_packets_total{peer="server",direction="in"}`,
+		`proxy_packets_total{peer="server",direction="out"}`,
+		`mcpe_batch_compression_ratio_count{op="decode"}`,
+	} {
+		if samples[name] == "" || samples[name] == "0" {
+			t.Errorf("metric %s is %q, expected more than 0", name, samples[name])
+		}
+	}
+}
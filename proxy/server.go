@@ -1,15 +1,100 @@
 package proxy
 
-import "net"
+import (
+	"net"
+	"sync"
+	"time"
+)
 
 type Server struct {
 	Name    string
 	Address *net.UDPAddr
+	// How long to wait for the server to accept a player before giving up.
+	HandshakeTimeout time.Duration
+	// How often this server is picked, relative to the other servers in a
+	// group using the random strategy.
+	Weight int
+	// Whether players need the proxy.server.<name> permission to join.
+	Restricted bool
+
+	// INTERNAL: health check state
+	statusLock sync.RWMutex
+	status     ServerStatus
+	successes  int
+	failures   int
+}
+
+// What we know about a server from the last health check.
+type ServerStatus struct {
+	Online     bool
+	Motd       string
+	Players    int
+	MaxPlayers int
+	Protocol   int
+	Version    string
+	Latency    time.Duration
+	LastCheck  time.Time
 }
 
-func NewServer(name string, address *net.UDPAddr) (this *Server) {
+func NewServer(name string, address *net.UDPAddr, handshakeTimeout time.Duration) (this *Server) {
 	this = new(Server)
 	this.Name = name
 	this.Address = address
+	this.HandshakeTimeout = handshakeTimeout
+	this.Weight = 1
+	// Until we've checked, assume the server is up.
+	this.status.Online = true
 	return this
 }
+
+func (this *Server) Status() ServerStatus {
+	this.statusLock.RLock()
+	defer this.statusLock.RUnlock()
+	return this.status
+}
+
+func (this *Server) IsOnline() bool {
+	this.statusLock.RLock()
+	defer this.statusLock.RUnlock()
+	return this.status.Online
+}
+
+// Whether the server reported that it has no room for more players.
+func (this *Server) IsFull() bool {
+	this.statusLock.RLock()
+	defer this.statusLock.RUnlock()
+	return this.status.MaxPlayers > 0 && this.status.Players >= this.status.MaxPlayers
+}
+
+// Records the result of a health check. A server is only marked as up after
+// rise successful checks in a row, and as down after fall failed checks in a
+// row, so a single lost ping doesn't send players elsewhere.
+func (this *Server) recordCheck(status *ServerStatus, rise int, fall int) (changed bool) {
+	this.statusLock.Lock()
+	defer this.statusLock.Unlock()
+
+	online := this.status.Online
+	if status != nil {
+		this.failures = 0
+		this.successes++
+		this.status = *status
+		this.status.Online = online || this.successes >= rise
+	} else {
+		this.successes = 0
+		this.failures++
+		this.status.LastCheck = time.Now()
+		this.status.Online = online && this.failures < fall
+	}
+	return online != this.status.Online
+}
+
+// Takes over the health check state of the server this one replaces.
+func (this *Server) inheritStatus(old *Server) {
+	old.statusLock.RLock()
+	defer old.statusLock.RUnlock()
+	this.statusLock.Lock()
+	defer this.statusLock.Unlock()
+	this.status = old.status
+	this.successes = old.successes
+	this.failures = old.failures
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN    = "round-robin"
+	STRATEGY_LEAST_PLAYERS  = "least-players"
+	STRATEGY_LOWEST_LATENCY = "lowest-latency"
+	STRATEGY_RANDOM         = "random"
+)
+
+type ServerGroupConfig struct {
+	Servers  []string `toml:"servers"`
+	Strategy string   `toml:"strategy"`
+}
+
+// A group of interchangeable servers, such as a set of lobbies. Players sent
+// to a group are balanced across its members.
+type ServerGroup struct {
+	Name     string
+	Servers  []*Server
+	Strategy string
+
+	// INTERNAL: used by the round-robin strategy
+	next uint32
+}
+
+func NewServerGroup(name string, servers []*Server, strategy string) (this *ServerGroup) {
+	this = new(ServerGroup)
+	this.Name = name
+	this.Servers = servers
+	this.Strategy = strategy
+	return
+}
+
+func isValidStrategy(strategy string) bool {
+	switch strategy {
+	case STRATEGY_ROUND_ROBIN, STRATEGY_LEAST_PLAYERS, STRATEGY_LOWEST_LATENCY, STRATEGY_RANDOM:
+		return true
+	}
+	return false
+}
+
+// Returns the members of this group that can take players right now, best
+// choice first.
+func (this *ServerGroup) Order() []*Server {
+	var available []*Server
+	for _, server := range this.Servers {
+		if server.IsOnline() && !server.IsFull() {
+			available = append(available, server)
+		}
+	}
+	if len(available) < 2 {
+		return available
+	}
+
+	switch this.Strategy {
+	case STRATEGY_ROUND_ROBIN:
+		start := int(atomic.AddUint32(&this.next, 1)-1) % len(available)
+		available = append(available[start:], available[:start]...)
+	case STRATEGY_LEAST_PLAYERS:
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].Status().Players < available[j].Status().Players
+		})
+	case STRATEGY_LOWEST_LATENCY:
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].Status().Latency < available[j].Status().Latency
+		})
+	case STRATEGY_RANDOM:
+		available = weightedShuffle(available)
+	}
+	return available
+}
+
+// Shuffles servers so that servers with a higher weight are more likely to
+// come first.
+func weightedShuffle(servers []*Server) []*Server {
+	remaining := append([]*Server(nil), servers...)
+	shuffled := make([]*Server, 0, len(servers))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, server := range remaining {
+			total += server.Weight
+		}
+
+		pick := 0
+		if total > 0 {
+			n := rand.Intn(total)
+			for i, server := range remaining {
+				n -= server.Weight
+				if n < 0 {
+					pick = i
+					break
+				}
+			}
+		}
+
+		shuffled = append(shuffled, remaining[pick])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+	return shuffled
+}
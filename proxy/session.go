@@ -6,11 +6,14 @@ import (
 	"../util"
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/pborman/uuid"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +26,8 @@ type Session struct {
 	mtu              int16
 	serverConnection *SessionConnector
 
+	// INTERNAL: guards serverConnection, as the console and admin API read it
+	serverConnectionLock sync.Mutex
 	// INTERNAL: channel used to send packets for processing
 	processQueue chan []byte
 	// INTERNAL: timer used for periodic tick task
@@ -35,8 +40,8 @@ type Session struct {
 	ackQueueLock   sync.Mutex
 	// INTERNAL
 	state sessionState
-	// INTERNAL: marks a session as abandoned
-	abandoned bool
+	// INTERNAL: marks a session as abandoned, only accessed atomically
+	abandoned int32
 	// INTERNAL: last ping message received time
 	lastPing time.Time
 	// INTERNAL
@@ -48,6 +53,12 @@ type Session struct {
 	loginPkt *mcpe.MCPELogin
 	// INTERNAL: stores last known dimension
 	dimension byte
+	// INTERNAL: maps the player's entity ID on the current server to the one
+	// the client knows, set up by the first server
+	entityIds EntityIdRewriter
+	// INTERNAL: what the current server has spawned on the client
+	entities *EntityTracker
+	chunks   *ChunkTracker
 }
 
 func NewSession(proxy *Proxy, mtu int16, endpoint *net.UDPAddr) (this *Session) {
@@ -57,7 +68,6 @@ func NewSession(proxy *Proxy, mtu int16, endpoint *net.UDPAddr) (this *Session)
 	this.endpoint = endpoint
 	this.state = STATE_IDENTIFY
 	this.splitPackets = raknet.NewSplitPacketHandler()
-	this.abandoned = false
 	this.lastPing = time.Now() // otherwise the client gets d/c'ed
 
 	this.processQueue = make(chan []byte, 300) // 300 should be more than enough
@@ -66,12 +76,14 @@ func NewSession(proxy *Proxy, mtu int16, endpoint *net.UDPAddr) (this *Session)
 	this.timer = time.NewTicker(50 * time.Millisecond) // MiNET uses this
 	this.ackQueue = make([]int32, 0)                   // this should be enough
 	this.datagramHelper = raknet.NewDatagramHelper(this)
+	this.entities = NewEntityTracker()
+	this.chunks = NewChunkTracker()
 
 	return
 }
 
 func (this *Session) IsAlive() bool {
-	return !this.abandoned
+	return atomic.LoadInt32(&this.abandoned) == 0
 }
 
 func (this *Session) GetEndpointString() string {
@@ -88,22 +100,7 @@ func (this *Session) handleSession() {
 			pktData := pktBytes[1:]
 			switch pktBytes[0] {
 			case raknet.ID_CONNECTED_PING:
-				pkt := new(raknet.RakNetConnectedPing)
-				err := pkt.Decode(bytes.NewReader(pktData))
-				if err != nil {
-					log.Printf("Error whilst handling message from %s", this.endpoint.String(), err)
-					break
-				}
-
-				log.Printf("Handling a connected ping packet from %s.", this.endpoint.String())
-				reply := raknet.RakNetConnectedPong{
-					Timestamp1: pkt.Timestamp,
-					Timestamp2: raknet.GetTimeMilliseconds(),
-				}
-				if err = this.SendPacket(reply); err != nil {
-					log.Printf("Error whilst handling message from %s", this.endpoint.String(), err)
-					break
-				}
+				this.handleConnectedPing(pktData, false)
 			case raknet.ID_DISCONNECT_NOTIFICATION:
 				// Player wants to disconnect. Signal an abandoned connection.
 				// This very same goroutine will pick it up and actually abandon this
@@ -143,27 +140,25 @@ func (this *Session) handleSession() {
 				this.AbandonWithReason("Ping timeout")
 			} else {
 				// Drain acks
-				/*this.ackQueueLock.Lock()
-				  var toAck []int
-				  for item := range this.ackQueue {
-				    toAck = append(toAck, int(item))
-				  }
-				  this.ackQueue = make([]int32, 0)
-				  this.ackQueueLock.Unlock()
-
-				  if len(toAck) > 0 {
-				    ackPkt := new(raknet.RakNetAck)
-				    ackPkt.Acknowledged = raknet.SliceAck(toAck)
-				    if err := this.SendPacket(ackPkt); err != nil {
-				      log.Printf("Unable to send acks for %s: %s", this.endpoint.String(), err.Error())
-				    }
-				  }*/
+				this.ackQueueLock.Lock()
+				var toAck []int
+				for _, item := range this.ackQueue {
+					toAck = append(toAck, int(item))
+				}
+				this.ackQueue = make([]int32, 0)
+				this.ackQueueLock.Unlock()
+
+				if len(toAck) > 0 {
+					ackPkt := new(raknet.RakNetAck)
+					ackPkt.Acknowledged = raknet.SliceAck(toAck)
+					if err := this.SendPacket(ackPkt); err != nil {
+						log.Printf("Unable to send acks for %s: %s", this.endpoint.String(), err.Error())
+					}
+				}
 			}
 		case <-this.poison:
-			// Commit suicide.
-			this.abandoned = true
-			close(this.processQueue)
-			close(this.poison)
+			// Commit suicide. The channels are left open, as the listener may
+			// still be about to send to processQueue.
 			this.timer.Stop()
 			return
 		}
@@ -188,39 +183,293 @@ func (this *Session) SendPackage(pkg raknet.EncodablePacket) error {
 	return nil
 }
 
-func (this *Session) SendDirect(pkt []byte) (err error) {
-	_, err = this.proxy.conn.WriteToUDP(pkt, this.endpoint)
-	return
+func (this *Session) SendDirect(pkt []byte) error {
+	return this.proxy.sendDirect(this.endpoint, pkt)
 }
 
 func (this *Session) SendPacket(pkt raknet.EncodablePacket) error {
-	return raknet.WriteUDP(this.proxy.conn, this.endpoint, pkt)
+	return this.proxy.sendTo(this.endpoint, pkt)
 }
 
-func (this *Session) Connect(server *Server) {
-	connector := NewSessionConnector(this, server)
-	firstServer := true
+// Returns the server this session is currently connected to, if any.
+func (this *Session) CurrentServer() *Server {
+	if conn := this.connection(); conn != nil {
+		return conn.server
+	}
+	return nil
+}
+
+// Returns the connection to the server the player is on, if any.
+func (this *Session) connection() *SessionConnector {
+	this.serverConnectionLock.Lock()
+	defer this.serverConnectionLock.Unlock()
+	return this.serverConnection
+}
+
+// Makes conn the player's server connection, returning the one it replaces.
+func (this *Session) swapConnection(conn *SessionConnector) (old *SessionConnector) {
+	this.serverConnectionLock.Lock()
+	defer this.serverConnectionLock.Unlock()
+	old = this.serverConnection
+	this.serverConnection = conn
+	return
+}
+
+// Sends a chat message to this session.
+func (this *Session) SendMessage(msg string) error {
+	return this.SendPackage(mcpe.MCPEText{mcpe.TEXT_TYPE_RAW, "", msg})
+}
 
-	if this.serverConnection != nil {
-		firstServer = false
+// The player's username, or their address if they haven't logged in yet.
+func (this *Session) Name() string {
+	if name := this.username; name != nil {
+		return *name
 	}
+	return this.endpoint.String()
+}
+
+// The player's username, or "" if they haven't logged in yet.
+func (this *Session) Username() string {
+	if name := this.username; name != nil {
+		return *name
+	}
+	return ""
+}
+
+func (this *Session) Endpoint() *net.UDPAddr {
+	return this.endpoint
+}
+
+// The player's UUID, or nil if they haven't logged in yet.
+func (this *Session) UUID() uuid.UUID {
+	if id := this.uuid; id != nil {
+		return *id
+	}
+	return nil
+}
+
+// How long the client takes to acknowledge what we send it.
+func (this *Session) Ping() time.Duration {
+	return this.datagramHelper.RoundTripTime()
+}
+
+func (this *Session) HasPermission(node string) bool {
+	return this.proxy.HasPermission(this, node)
+}
+
+// Whether this player may be sent to a server. Restricted servers need the
+// proxy.server.<name> permission.
+func (this *Session) CanJoin(server *Server) bool {
+	return !server.Restricted || this.HasPermission("proxy.server."+server.Name)
+}
+
+// Tries each server in turn until one of them accepts this session. If none
+// do, an error suitable for showing to the player is returned.
+func (this *Session) ConnectAny(servers []*Server) error {
+	var tried []string
+	var lastErr error
+	for _, server := range servers {
+		if !this.IsAlive() {
+			return errors.New("Session abandoned")
+		}
+
+		var err error
+		if !this.CanJoin(server) {
+			err = errors.New("You don't have permission to join it")
+		} else if server.IsOnline() {
+			err = this.Connect(server)
+		} else {
+			err = errors.New("Server is down")
+		}
+		if err == nil {
+			return nil
+		}
 
-	err := connector.Connect()
+		log.Printf("Unable to connect %s to %s, skipping it: %s", this.endpoint.String(), server.Name, err.Error())
+		tried = append(tried, server.Name)
+		lastErr = err
+	}
+
+	if len(tried) == 0 {
+		return errors.New("No servers are available right now.")
+	}
+	if len(tried) == 1 {
+		return fmt.Errorf("Unable to connect to %s: %s", tried[0], lastErr.Error())
+	}
+	return fmt.Errorf("Unable to connect to any server (tried %s).", strings.Join(tried, ", "))
+}
+
+// Connects this session to a server, or to a member of a group.
+func (this *Session) ConnectTo(target string) error {
+	chain, err := this.switchChain(target)
 	if err != nil {
-		msg := fmt.Sprintf("Unable to connect to %s: %s", server.Name, err.Error())
-		if firstServer {
-			this.AbandonWithReason(msg)
+		return err
+	}
+	return this.ConnectAny(chain)
+}
+
+// Returns the servers to try, in order, to move this session to a server or
+// group. The error is suitable for showing to the player.
+func (this *Session) switchChain(target string) ([]*Server, error) {
+	servers := this.proxy.Resolve(target)
+	if len(servers) == 0 {
+		if this.proxy.GetGroup(target) != nil {
+			return nil, fmt.Errorf("No server in %s is available right now.", target)
+		}
+		return nil, fmt.Errorf("There is no server called %s.", target)
+	}
+
+	// A group may well contain the server the player is already on, which
+	// would log them in there a second time. Reloading makes new servers, so
+	// they are told apart by name.
+	current := this.CurrentServer()
+	var chain []*Server
+	for _, server := range servers {
+		if current == nil || server.Name != current.Name {
+			chain = append(chain, server)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("You are already connected to %s.", current.Name)
+	}
+	return chain, nil
+}
+
+// Connects this session to a server, waiting until the server has accepted
+// the player or the server's handshake timeout has passed.
+func (this *Session) Connect(server *Server) error {
+	connector := NewSessionConnector(this, server)
+
+	started := time.Now()
+	if err := connector.Connect(); err != nil {
+		handshakeFailures.With(server.Name).Inc()
+		connector.Close()
+		return err
+	}
+
+	var err error
+	select {
+	case err = <-connector.handshakeResult:
+	case <-time.After(server.HandshakeTimeout):
+		if connector.settle() {
+			err = fmt.Errorf("Timed out after %s", server.HandshakeTimeout)
 		} else {
-			chat := mcpe.MCPEText{mcpe.TEXT_TYPE_RAW, "", msg}
-			if e := this.SendPackage(chat); e != nil {
-				// TODO: What?
+			// The server got there just in time, and the player is being
+			// moved over to it.
+			err = <-connector.handshakeResult
+		}
+	}
+	if err != nil {
+		handshakeFailures.With(server.Name).Inc()
+		connector.Close()
+		return err
+	}
+	handshakeSeconds.With(server.Name).Observe(time.Since(started).Seconds())
+	return nil
+}
+
+// Keeps track of what the server sends the client, so it can be undone when
+// the player switches servers.
+func (this *Session) trackClientbound(pkt []byte) {
+	this.entities.Track(pkt)
+	this.chunks.Track(pkt)
+	if len(pkt) > 1 && pkt[0] == mcpe.ID_MCPE_CHANGE_DIMENSION {
+		this.dimension = pkt[1]
+	}
+}
+
+// Removes every entity and player the previous server spawned on the client.
+func (this *Session) despawnEntities() error {
+	batch, err := this.entities.Despawn()
+	if err != nil || batch == nil {
+		return err
+	}
+	log.Printf("Removing what the old server spawned for %s.", this.endpoint.String())
+	return this.SendPackage(batch)
+}
+
+// Replaces the previous server's world with the new one's. The client only
+// gets a start game packet from the first server, so everything else in it has
+// to be sent separately.
+func (this *Session) resetWorld(startGame *mcpe.MCPEStartGame) error {
+	if startGame.Dimension != this.dimension {
+		// Changing dimension gets rid of the old chunks for us.
+		this.chunks.Unload()
+		err := this.SendPackage(mcpe.MCPEChangeDimension{Dimension: startGame.Dimension, Location: startGame.Location})
+		if err != nil {
+			return err
+		}
+		this.dimension = startGame.Dimension
+	} else {
+		// Otherwise, the old chunks are overwritten with air. The new server
+		// will send its own in their place.
+		for _, pos := range this.chunks.Unload() {
+			batch := new(mcpe.MCPEBatch)
+			if err := batch.AddPacket(mcpe.NewEmptyChunk(pos.X, pos.Z)); err != nil {
+				return err
+			}
+			if err := this.SendPackage(batch); err != nil {
+				return err
 			}
 		}
 	}
+
+	// The new server will send the real time and difficulty itself, but may
+	// not get around to it straight away.
+	pkts := []raknet.EncodablePacket{
+		mcpe.MCPESetSpawnPosition{startGame.Spawn},
+		mcpe.MCPESetPlayerGametype{startGame.Gamemode},
+		mcpe.MCPESetTime{0, true},
+		mcpe.MCPERespawn{startGame.Location},
+	}
+	for _, pkt := range pkts {
+		if err := this.SendPackage(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Called when the server this session is connected to kicks the player. The
+// player is either moved to a fallback server or disconnected.
+func (this *Session) handleKick(connector *SessionConnector, reason string) {
+	if !connector.IsAlive() || !atomic.CompareAndSwapInt32(&connector.kicked, 0, 1) {
+		// Already dealt with.
+		return
+	}
+	if connector != this.connection() {
+		// Not the server the player is on any more.
+		connector.Close()
+		return
+	}
+
+	server := connector.server
+	connector.Close()
+
+	fallback := this.proxy.KickFallback(server, reason)
+	if fallback == "" {
+		this.AbandonWithReason(fmt.Sprintf("Disconnected from %s: %s", server.Name, reason))
+		return
+	}
+
+	log.Printf("%s was kicked from %s (%s), moving them to %s.", this.endpoint.String(), server.Name, reason, fallback)
+	this.SendMessage(fmt.Sprintf("You were kicked from %s: %s", server.Name, reason))
+
+	go func() {
+		var chain []*Server
+		for _, candidate := range this.proxy.ConnectionChain(fallback) {
+			if candidate.Name != server.Name {
+				chain = append(chain, candidate)
+			}
+		}
+		if err := this.ConnectAny(chain); err != nil {
+			this.AbandonWithReason(fmt.Sprintf("Disconnected from %s: %s", server.Name, reason))
+		}
+	}()
 }
 
 func (this *Session) AbandonWithReason(reason string) bool {
-	if this.abandoned {
+	if !this.IsAlive() {
 		return false // already abandoned!
 	}
 
@@ -233,43 +482,80 @@ func (this *Session) AbandonWithReason(reason string) bool {
 }
 
 func (this *Session) Abandon() bool {
-	if this.abandoned {
+	// The console, the admin API and the session itself may all get here at
+	// once, but only the first one gets to abandon it.
+	if !atomic.CompareAndSwapInt32(&this.abandoned, 0, 1) {
 		return false // already abandoned!
 	}
 
 	// Unregister ourselves
 	this.proxy.Registry.Unregister(this)
 
-	// Cancel the player's goroutine task
-	// This will also close the processQueue channel
+	// Cancel the player's goroutine task. Only we send to poison, so this
+	// never blocks.
 	this.poison <- struct{}{}
 
 	// If the player is connected, abandon their connection too.
-	if this.serverConnection != nil {
-		this.serverConnection.Close()
+	if conn := this.connection(); conn != nil {
+		conn.Close()
 	}
 
 	return true
 }
 
-func safeSlice(p []byte, end int) []byte {
-	ln := len(p)
-	if end >= ln {
-		end = ln - 1
+func (this *Session) dispatchData(pktBytes []byte) {
+	// These mean the same thing whatever state we're in.
+	switch pktBytes[0] {
+	case raknet.ID_DATA_4, raknet.ID_DATA_C:
+		this.handleDatagram(pktBytes)
+		return
+	case raknet.ID_CONNECTED_PING:
+		this.handleConnectedPing(pktBytes[1:], true)
+		return
+	case raknet.ID_CONNECTED_PONG:
+		return
+	case raknet.ID_DISCONNECT_NOTIFICATION:
+		this.Abandon()
+		return
 	}
-	return p[0:end]
-}
 
-func (this *Session) dispatchData(pktBytes []byte) {
-	log.Printf("DISPATCHED: %s", hex.EncodeToString(safeSlice(pktBytes, 32)))
-	if this.state == STATE_IDENTIFY {
+	switch this.state {
+	case STATE_IDENTIFY:
 		this.handleIdentify(pktBytes)
+	case STATE_CONNECTING:
+		// There's no server to send this to yet. Clients don't send anything
+		// that matters until they have been sent StartGame.
+		log.Printf("Dropping packet %#x from %s, it isn't connected to a server yet.", pktBytes[0], this.endpoint.String())
+	case STATE_CONNECTED:
+		this.handleConnected(pktBytes)
 	}
 }
 
-func (this *Session) handleMcpeBatch(pktData []byte) {
-	log.Println("Our packet data:", hex.EncodeToString(pktData))
+// Replies to a ping from the client. Pings inside datagrams are answered
+// inside one too.
+func (this *Session) handleConnectedPing(pktData []byte, encapsulated bool) {
+	pkt := new(raknet.RakNetConnectedPing)
+	err := pkt.Decode(bytes.NewReader(pktData))
+	if err != nil {
+		log.Printf("Error whilst handling message from %s: %s", this.endpoint.String(), err.Error())
+		return
+	}
+
+	reply := raknet.RakNetConnectedPong{
+		Timestamp1: pkt.Timestamp,
+		Timestamp2: raknet.GetTimeMilliseconds(),
+	}
+	if encapsulated {
+		err = this.SendPackage(reply)
+	} else {
+		err = this.SendPacket(reply)
+	}
+	if err != nil {
+		log.Printf("Error whilst handling message from %s: %s", this.endpoint.String(), err.Error())
+	}
+}
 
+func (this *Session) handleMcpeBatch(pktData []byte) {
 	pkt := new(mcpe.MCPEBatch)
 	err := pkt.Decode(bytes.NewReader(pktData))
 	if err != nil {
@@ -278,7 +564,6 @@ func (this *Session) handleMcpeBatch(pktData []byte) {
 	}
 
 	for _, item := range pkt.Payload {
-		log.Printf("Handling decompressed packet: %s ...", hex.EncodeToString(item[0:15]))
 		this.dispatchData(item)
 	}
 }
@@ -297,10 +582,7 @@ func (this *Session) handleDatagram(pktBytes []byte) {
 		this.ackQueueLock.Unlock()
 	}()
 
-	log.Println("Datagram: ", pkt)
-
 	for _, item := range pkt.Payload {
-		log.Println("Datagram data part: ", *item)
 		if item.PartCount > 1 {
 			if allPackets := this.splitPackets.AcceptSplitPacket(*item); allPackets != nil {
 				// We have all the packets. Reconstruct them and handle it.
@@ -323,10 +605,6 @@ func (this *Session) handleIdentify(pktBytes []byte) {
 	pktData := pktBytes[1:]
 
 	switch pktBytes[0] {
-	case raknet.ID_DATA_4:
-		this.handleDatagram(pktBytes)
-	case raknet.ID_DATA_C:
-		this.handleDatagram(pktBytes)
 	case raknet.ID_CONNECTION_REQUEST:
 		pkt := new(raknet.RakNetConnectionRequest)
 		err := pkt.Decode(bytes.NewReader(pktData))
@@ -356,24 +634,126 @@ func (this *Session) handleIdentify(pktBytes []byte) {
 		}
 
 		//this.AbandonWithReason("Hello! You're being disconnected because I didn't implement proxying!")
+		if ban := this.proxy.Bans.CheckPlayer(lp.Username, lp.ClientUuid); ban != nil {
+			log.Printf("Disconnecting %s (%s), they are banned.", lp.Username, this.endpoint.String())
+			this.AbandonWithReason(ban.Message())
+			return
+		}
+
+		if !this.proxy.CanJoinWhitelisted(lp.Username, lp.ClientUuid) {
+			log.Printf("Disconnecting %s (%s), they aren't whitelisted.", lp.Username, this.endpoint.String())
+			this.AbandonWithReason(this.proxy.Config().WhitelistMessage)
+			return
+		}
+
+		if !this.proxy.CanJoinWhenFull(lp.Username, lp.ClientUuid) && this.proxy.Registry.Len() >= this.proxy.Config().MaxPlayers {
+			log.Printf("Disconnecting %s (%s), the proxy is full.", lp.Username, this.endpoint.String())
+			this.AbandonWithReason(this.proxy.Config().FullMessage)
+			return
+		}
+
+		this.username = &lp.Username
+		this.uuid = &lp.ClientUuid
+		if !this.proxy.Registry.RegisterName(this) {
+			// Don't let Unregister remove the session that is already logged in.
+			this.username = nil
+			this.AbandonWithReason("You are already connected to this proxy.")
+			return
+		}
+
 		log.Println("Log in successful, attempting a connection now...")
 		this.loginPkt = lp
-		this.Connect(&Server{
-			Address: &net.UDPAddr{
-				IP:   net.IPv4(127, 0, 0, 1),
-				Port: 19134,
-			},
-			Name: "test",
-		})
+		this.state = STATE_CONNECTING
+		go func() {
+			chain := this.proxy.ConnectionChain(this.proxy.ServerForHost(lp.ServerAddress))
+			if err := this.ConnectAny(chain); err != nil {
+				this.AbandonWithReason(err.Error())
+			}
+		}()
 	default:
 		log.Printf("Unknown packet: %s", hex.EncodeToString(pktBytes))
 	}
 }
 
 func (this *Session) handleConnected(pktBytes []byte) {
-	// Perform serverbound message rewriting, if required.
-	// TODO: Handle rewriting.
+	switch pktBytes[0] {
+	case mcpe.ID_MCPE_BATCH:
+		this.handleConnectedBatch(pktBytes)
+		return
+	case mcpe.ID_MCPE_TEXT:
+		if this.handleChat(pktBytes[1:]) {
+			return
+		}
+	}
 
-	// Forward the message on.
-	this.serverConnection.packetQueue <- pktBytes
+	this.forward(this.entityIds.current().RewriteServerbound(pktBytes))
+}
+
+// Relays a batch, minus any commands for the proxy in it.
+func (this *Session) handleConnectedBatch(pktBytes []byte) {
+	pkt := new(mcpe.MCPEBatch)
+	if err := pkt.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+		log.Printf("Error whilst handling message from %s: %s", this.endpoint.String(), err.Error())
+		return
+	}
+
+	rewriter := this.entityIds.current()
+	var keep [][]byte
+	for _, item := range pkt.Payload {
+		if len(item) == 0 {
+			continue
+		}
+		if item[0] == mcpe.ID_MCPE_TEXT && this.handleChat(item[1:]) {
+			continue
+		}
+		keep = append(keep, rewriter.RewriteServerbound(item))
+	}
+
+	if len(keep) == len(pkt.Payload) && !rewriter.changesIds() {
+		// Nothing changed, so save ourselves compressing it again.
+		this.forward(pktBytes)
+		return
+	}
+	if len(keep) == 0 {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := (mcpe.MCPEBatch{keep}).Encode(buf); err != nil {
+		log.Printf("Unable to repack a batch from %s: %s", this.endpoint.String(), err.Error())
+		return
+	}
+	this.forward(buf.Bytes())
+}
+
+// Hands a packet to the server connection, to be sent on.
+func (this *Session) forward(pktBytes []byte) {
+	if conn := this.connection(); conn != nil && conn.IsAlive() {
+		select {
+		case conn.packetQueue <- pktBytes:
+		default:
+			log.Printf("Dropping a packet from %s, %s isn't keeping up.", this.endpoint.String(), conn.server.Name)
+		}
+	}
+}
+
+// Runs proxy commands typed into chat. Returns false if the message should go
+// on to the server, which includes commands the proxy doesn't know about.
+func (this *Session) handleChat(pktData []byte) bool {
+	pkt := new(mcpe.MCPEText)
+	if err := pkt.Decode(bytes.NewReader(pktData)); err != nil {
+		return false
+	}
+	if pkt.Type != mcpe.TEXT_TYPE_CHAT || !strings.HasPrefix(pkt.Message, "/") {
+		return false
+	}
+
+	err := this.proxy.Commands.Dispatch(this, pkt.Message[1:])
+	if err == ErrUnknownCommand {
+		return false
+	}
+	if err != nil {
+		log.Printf("Unable to reply to %s's command: %s", this.Name(), err.Error())
+	}
+	return true
 }
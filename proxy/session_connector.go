@@ -1,16 +1,17 @@
 package proxy
 
 import (
+	"../forwarding"
 	"../packets/mcpe"
 	"../packets/raknet"
 	"../util"
 	"bytes"
-	"encoding/hex"
-	"fmt"
+	"errors"
 	"log"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,17 +30,30 @@ type SessionConnector struct {
 	fastTimer *time.Ticker
 	slowTimer *time.Ticker
 	// INTERNAL: used to communicate acks
-	ackQueue     []int32
-	ackQueueLock sync.Mutex
-	mtu          int16
-	guid         int64
-	state        sessionConnectorState
+	datagramHelper *raknet.DatagramHelper
+	ackQueue       []int32
+	ackQueueLock   sync.Mutex
+	mtu            int16
+	guid           int64
+	state          sessionConnectorState
 
 	// INTERNAL: Used to communicate player packets to the backend
 	packetQueue chan []byte
 	// INTERNAL: Used only when the connection is to be closed
 	closeChan chan struct{}
-	abandoned bool
+	// INTERNAL: set once the connection is closed, only accessed atomically
+	abandoned int32
+	// INTERNAL: set once the session has dealt with this connection ending,
+	// only accessed atomically
+	kicked int32
+	// INTERNAL: Receives the outcome of the handshake with the server
+	handshakeResult chan error
+	// INTERNAL: set once the handshake has either taken over the session or
+	// been given up on, only accessed atomically
+	settled int32
+	// INTERNAL: When we last heard from the server, in Unix nanoseconds, only
+	// accessed atomically
+	lastReceived int64
 }
 
 func NewSessionConnector(session *Session, server *Server) (this *SessionConnector) {
@@ -51,9 +65,11 @@ func NewSessionConnector(session *Session, server *Server) (this *SessionConnect
 	this.fastTimer = time.NewTicker(50 * time.Millisecond) // MiNET uses this
 	this.slowTimer = time.NewTicker(5 * time.Second)
 	this.packetQueue = make(chan []byte, 300) // _more_ than enough!
-	this.closeChan = make(chan struct{})
+	this.closeChan = make(chan struct{}, 1)
+	this.handshakeResult = make(chan error, 1)
+	this.datagramHelper = raknet.NewDatagramHelper(this)
 
-	if session.serverConnection == nil {
+	if session.connection() == nil {
 		this.firstServer = true
 	}
 
@@ -66,7 +82,7 @@ func NewSessionConnector(session *Session, server *Server) (this *SessionConnect
 }
 
 func (this *SessionConnector) IsAlive() bool {
-	return !this.abandoned
+	return atomic.LoadInt32(&this.abandoned) == 0
 }
 
 func (this *SessionConnector) GetEndpointString() string {
@@ -74,9 +90,17 @@ func (this *SessionConnector) GetEndpointString() string {
 }
 
 func (this *SessionConnector) Close() (err error) {
+	// Both the session and a kick may be closing this at once.
+	if !atomic.CompareAndSwapInt32(&this.abandoned, 0, 1) {
+		return nil
+	}
+
 	this.fastTimer.Stop()
 	this.slowTimer.Stop()
-	this.abandoned = true
+
+	if this.conn == nil {
+		return
+	}
 
 	// Send a disconnect packet
 	err = this.SendPacket(raknet.RakNetDisconnectNotification{})
@@ -92,11 +116,32 @@ func (this *SessionConnector) Close() (err error) {
 	return
 }
 
+// Reports the outcome of the handshake, if nobody has been told yet.
+func (this *SessionConnector) finishHandshake(err error) {
+	select {
+	case this.handshakeResult <- err:
+	default:
+	}
+}
+
+// Decides the handshake, if it hasn't been already. Only the first caller gets
+// true, so a connection that has just timed out can't still take over the
+// session, and one that has taken over isn't given up on.
+func (this *SessionConnector) settle() bool {
+	return atomic.CompareAndSwapInt32(&this.settled, 0, 1)
+}
+
+// Notes that we have just heard from the server.
+func (this *SessionConnector) touch() {
+	atomic.StoreInt64(&this.lastReceived, time.Now().UnixNano())
+}
+
 func (this *SessionConnector) Process() {
 	for {
 		select {
 		case <-this.closeChan:
-			close(this.packetQueue)
+			// packetQueue is left open, as the session may still be about to
+			// send to it.
 			close(this.closeChan)
 			return
 		case <-this.slowTimer.C:
@@ -104,35 +149,32 @@ func (this *SessionConnector) Process() {
 			if err := this.SendPackage(pkt); err != nil {
 				// TODO: Handle gracefully
 			}
-		case pkt := <-this.packetQueue:
-			// Reframe all received packets.
-			all, err := this.handleDatagram(pkt)
-			if err != nil {
-				log.Printf("Unable to reassemble packet for %s: %s", this.server.Address.String(), err.Error())
-				break
-			}
-			// Nothing? Then return, we'll have something soon.
-			if all == nil {
-				break
+		case p := <-this.packetQueue:
+			// Packets from the player, already taken out of their datagrams.
+			repackaged := raknet.GenericRakNetPackage{
+				PacketId: p[0],
+				Payload:  p[1:],
 			}
-
-			for _, p := range *all {
-				repackaged := raknet.GenericRakNetPackage{
-					PacketId: p[0],
-					Payload:  p[1:],
-				}
-				if err = this.session.SendPackage(repackaged); err != nil {
-					log.Printf("Unable to send packet to %s: %s", this.server.Address.String(), err.Error())
-					break
-				}
+			if err := this.SendPackage(repackaged); err != nil {
+				log.Printf("Unable to send packet to %s: %s", this.server.Address.String(), err.Error())
 			}
 		case <-this.fastTimer.C:
 			this.splitPackets.GarbageCollect()
+			this.datagramHelper.TryResendPackets()
+
+			lastReceived := time.Unix(0, atomic.LoadInt64(&this.lastReceived))
+			if lastReceived.Add(10 * time.Second).Before(time.Now()) {
+				log.Printf("Connection to %s for %s timed out.", this.server.Name, this.session.endpoint.String())
+				// Nothing more to do for this connection, so don't go round
+				// again and time out a second time.
+				this.session.handleKick(this, "Timed out")
+				return
+			}
 
 			// Drain acks
 			this.ackQueueLock.Lock()
 			var toAck []int
-			for item := range this.ackQueue {
+			for _, item := range this.ackQueue {
 				toAck = append(toAck, int(item))
 			}
 			this.ackQueue = make([]int32, 0)
@@ -158,6 +200,7 @@ func (this *SessionConnector) Connect() (err error) {
 
 	this.conn = c
 	this.state = C_STATE_IDENTIFY
+	this.touch()
 	go this.connectionListener()
 
 	// Send the first handshake
@@ -167,8 +210,15 @@ func (this *SessionConnector) Connect() (err error) {
 }
 
 func (this *SessionConnector) SendPacket(pkt raknet.EncodablePacket) error {
-	log.Println("Sending to backend:", pkt)
-	return raknet.WriteUDPPreConnected(this.conn, pkt)
+	out := new(bytes.Buffer)
+	if err := pkt.Encode(out); err != nil {
+		return err
+	}
+	if _, err := this.conn.Write(out.Bytes()); err != nil {
+		return err
+	}
+	countPacket(PEER_SERVER, DIRECTION_OUT, out.Len())
+	return nil
 }
 
 func (this *SessionConnector) SendPackage(pkg raknet.EncodablePacket) error {
@@ -179,6 +229,7 @@ func (this *SessionConnector) SendPackage(pkg raknet.EncodablePacket) error {
 	}
 
 	for _, item := range *encapsulated {
+		this.datagramHelper.RegisterDatagram(item)
 		err = this.SendPacket(item)
 		if err != nil {
 			return err
@@ -194,12 +245,17 @@ func (this *SessionConnector) connectionListener() {
 		read, _, err := this.conn.ReadFromUDP(buf)
 
 		if err != nil {
-			log.Println("Encountered an error while handling backend connection:", err)
+			if this.IsAlive() {
+				log.Println("Encountered an error while handling backend connection:", err)
+			}
+			this.finishHandshake(err)
 			// TODO: Graceful handling of this situation.
 			return
 		}
 
 		pktBytes := buf[0:read]
+		this.touch()
+		countPacket(PEER_SERVER, DIRECTION_IN, read)
 
 		this.dispatchData(pktBytes)
 	}
@@ -207,6 +263,30 @@ func (this *SessionConnector) connectionListener() {
 
 func (this *SessionConnector) dispatchData(pktBytes []byte) {
 	//log.Printf("Backend DISPATCHED: %s", hex.EncodeToString(pktBytes))
+	if len(pktBytes) == 0 {
+		return
+	}
+
+	// The server acks what we send it, whatever state we're in.
+	switch pktBytes[0] {
+	case raknet.ID_ACK:
+		pkt := new(raknet.RakNetAck)
+		if err := pkt.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+			log.Printf("Unable to handle an ack from %s: %s", this.server.Name, err.Error())
+			return
+		}
+		this.datagramHelper.HandleAck(pkt)
+		return
+	case raknet.ID_NAK:
+		pkt := new(raknet.RakNetNak)
+		if err := pkt.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+			log.Printf("Unable to handle a nak from %s: %s", this.server.Name, err.Error())
+			return
+		}
+		this.datagramHelper.HandleNak(pkt)
+		return
+	}
+
 	if this.state == C_STATE_IDENTIFY {
 		this.handleIdentify(pktBytes)
 	} else if this.state == C_STATE_CONNECTED {
@@ -214,6 +294,20 @@ func (this *SessionConnector) dispatchData(pktBytes []byte) {
 	}
 }
 
+// Like dispatchData, but for packets taken out of a datagram or batch. Some
+// MCPE packet IDs are the same as RakNet's ACK and NAK, so these have to be
+// kept apart.
+func (this *SessionConnector) dispatchPacket(pktBytes []byte) {
+	if len(pktBytes) == 0 {
+		return
+	}
+	if this.state == C_STATE_IDENTIFY {
+		this.handleIdentify(pktBytes)
+	} else if this.state == C_STATE_CONNECTED {
+		this.handleConnectedPacket(pktBytes)
+	}
+}
+
 func (this *SessionConnector) handleMcpeBatch(pktData []byte) {
 	//log.Println("Backend packet data:", hex.EncodeToString(pktData))
 
@@ -225,8 +319,7 @@ func (this *SessionConnector) handleMcpeBatch(pktData []byte) {
 	}
 
 	for _, item := range pkt.Payload {
-		log.Printf("Handling decompressed packet: %s ...", hex.EncodeToString(item[0:15]))
-		this.dispatchData(item)
+		this.dispatchPacket(item)
 	}
 }
 
@@ -271,10 +364,10 @@ func (this *SessionConnector) handleDatagramIdentify(pktBytes []byte) {
 		log.Println("Unable to handle datagram from backend:", err)
 		return
 	}
-	if len(*all) > 0 {
-		for _, item := range *all {
-			this.handleIdentify(item)
-		}
+	// StartGame may be followed by other packets in the same datagram, which
+	// have to be relayed, so this goes through dispatchPacket.
+	for _, item := range *all {
+		this.dispatchPacket(item)
 	}
 }
 
@@ -286,6 +379,16 @@ func (this *SessionConnector) handleIdentify(pktBytes []byte) (err error) {
 		this.handleDatagramIdentify(pktBytes)
 	case raknet.ID_DATA_C:
 		this.handleDatagramIdentify(pktBytes)
+	case mcpe.ID_MCPE_BATCH:
+		this.handleMcpeBatch(pktData)
+	case mcpe.ID_MCPE_PLAYER_STATUS:
+		// The client only needs to hear that the login succeeded once.
+		if this.firstServer {
+			err = this.session.SendPackage(raknet.GenericRakNetPackage{
+				PacketId: pktBytes[0],
+				Payload:  pktData,
+			})
+		}
 	case raknet.ID_OPEN_CONNECTION_REPLY_1:
 		pkt := new(raknet.RakNetOpenConnectionReply1)
 		err = pkt.Decode(bytes.NewReader(pktData))
@@ -297,10 +400,17 @@ func (this *SessionConnector) handleIdentify(pktBytes []byte) (err error) {
 		this.mtu = pkt.MTU
 
 		// Send the second request packet.
+		endpoint := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132}
+		if this.session.proxy.Config().IPForward {
+			endpoint = this.session.endpoint
+		}
 		reply := raknet.RakNetOpenConnectionRequest2{
 			GUID:           this.guid,
-			ClientEndpoint: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132},
+			ClientEndpoint: endpoint,
 			MTU:            this.mtu,
+			// Echo the cookie if the server sent one.
+			Secure: pkt.Secure != 0,
+			Cookie: pkt.Cookie,
 		}
 
 		if err = this.SendPacket(reply); err != nil {
@@ -337,7 +447,7 @@ func (this *SessionConnector) handleIdentify(pktBytes []byte) (err error) {
 		}
 
 		batchPkt := new(mcpe.MCPEBatch)
-		if err = batchPkt.AddPacket(this.session.loginPkt); err != nil {
+		if err = batchPkt.AddPacket(this.loginPacket()); err != nil {
 			log.Println("Encountered an error while handling backend connection:", err)
 			// TODO: Graceful handling of this situation.
 			return
@@ -359,9 +469,9 @@ func (this *SessionConnector) handleIdentify(pktBytes []byte) (err error) {
 			return
 		}
 
-		// TODO: Would forwarding be more approriate?
+		// Whoever is connecting us will try another server or give up.
 		log.Printf("%s disconnected from %s: %s", this.session.endpoint.String(), this.server.Name, pkt.Message)
-		this.session.AbandonWithReason(fmt.Sprintf("Disconnected from %s: %s", this.server.Name, pkt.Message))
+		this.finishHandshake(errors.New(pkt.Message))
 	case mcpe.ID_MCPE_START_GAME:
 		pkt := new(mcpe.MCPEStartGame)
 		err = pkt.Decode(bytes.NewReader(pktData))
@@ -369,58 +479,176 @@ func (this *SessionConnector) handleIdentify(pktBytes []byte) (err error) {
 			return
 		}
 
+		// Whoever is connecting us may have given up already, in which case
+		// the player is on their way somewhere else.
+		if !this.IsAlive() || !this.settle() {
+			return
+		}
+
 		// If this is our first server, we'll simply forward this packet on.
-		// If it isn't, we'll send a respawn packet instead.
-		// TODO: Implement this properly.
+		// If it isn't, the client already has a world, so we'll clean up after
+		// the old server and respawn the player in the new world instead.
+		if this.firstServer {
+			this.session.entityIds = NewEntityIdRewriter(pkt.EntityId)
+			this.session.dimension = pkt.Dimension
+			err = this.session.SendPackage(raknet.GenericRakNetPackage{
+				PacketId: pktBytes[0],
+				Payload:  pktData,
+			})
+			if err != nil {
+				this.finishHandshake(err)
+				return
+			}
+		} else {
+			this.session.entityIds.SetNewServerId(pkt.EntityId)
+		}
+
 		go this.Process()
 		this.state = C_STATE_CONNECTED
 		this.session.state = STATE_CONNECTED
-		err = this.session.SendDirect(pktBytes)
-		if err != nil {
-			return
+		old := this.session.swapConnection(this)
+		if old != nil && old != this {
+			log.Printf("%s switched from %s to %s.", this.session.endpoint.String(), old.server.Name, this.server.Name)
+			old.Close()
+		}
+
+		if !this.firstServer {
+			// The old server is gone, so get rid of everything it spawned
+			// before the new server starts spawning things of its own.
+			err = this.session.despawnEntities()
+			if err == nil {
+				err = this.session.resetWorld(pkt)
+			}
 		}
+		this.finishHandshake(err)
 	}
 
 	return nil
 }
 
 func (this *SessionConnector) handleConnected(pktBytes []byte) (err error) {
-	// In case of ACK or NAK, don't send them to the client.
-	if pktBytes[0] == raknet.ID_ACK || pktBytes[0] == raknet.ID_NAK {
+	// ACKs and NAKs are between us and the server; don't send them to the
+	// client. Everything else comes in datagrams.
+	if pktBytes[0] == raknet.ID_DATA_4 || pktBytes[0] == raknet.ID_DATA_C {
+		payload, err := this.handleDatagram(pktBytes)
+		if err != nil {
+			return err
+		}
+		for _, p := range *payload {
+			this.dispatchPacket(p)
+		}
+	}
+	return
+}
+
+// Relays a single packet from the server to the client.
+func (this *SessionConnector) handleConnectedPacket(pktBytes []byte) (err error) {
+	// Once the player has moved on, whatever the old server still sends
+	// would only spawn things that never get removed.
+	if this.session.connection() != this {
+		return
+	}
+
+	switch pktBytes[0] {
+	case raknet.ID_CONNECTED_PONG:
 		return
+	case raknet.ID_CONNECTED_PING:
+		pkt := new(raknet.RakNetConnectedPing)
+		if err = pkt.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+			return
+		}
+		return this.SendPackage(raknet.RakNetConnectedPong{
+			Timestamp1: pkt.Timestamp,
+			Timestamp2: raknet.GetTimeMilliseconds(),
+		})
+	case mcpe.ID_MCPE_BATCH:
+		return this.handleConnectedBatch(pktBytes)
+	}
+
+	// Kicks are handled by the proxy, rather than being forwarded.
+	if reason, kicked := this.findKick(pktBytes); kicked {
+		this.session.handleKick(this, reason)
+		return nil
 	}
 
 	// Generally, we won't meddle with connected player's packets, except to
 	// rewrite entity IDs.
+	p := this.session.entityIds.current().RewriteClientbound(pktBytes)
+	this.session.trackClientbound(p)
+	return this.session.SendPackage(raknet.GenericRakNetPackage{
+		PacketId: p[0],
+		Payload:  p[1:],
+	})
+}
 
-	// NOTE: After initial authentication, we'll be sent datagrams. MiNET batches
-	// these into a single MCPEBatch datagram, requiring us to decompress the packet,
-	// read the data (if needed), and then reconstruct the final data. How we will deal
-	// with other servers (i.e. PocketMine-MP) is unknown.
+// Relays a batch to the client, rewriting the packets in it. Servers like
+// MiNET send nearly everything in batches.
+func (this *SessionConnector) handleConnectedBatch(pktBytes []byte) (err error) {
+	pkt := new(mcpe.MCPEBatch)
+	if err = pkt.Decode(bytes.NewReader(pktBytes[1:])); err != nil {
+		return
+	}
 
-	// TODO: Implement entity ID rewriting. For now, all we can do is repackage the
-	// datagram ourselves.
-	if pktBytes[0] == raknet.ID_DATA_4 || pktBytes[0] == raknet.ID_DATA_C {
-		payload, err := this.handleDatagram(pktBytes)
-		if err != nil {
-			return err
+	rewriter := this.session.entityIds.current()
+	for i, item := range pkt.Payload {
+		if len(item) == 0 {
+			continue
 		}
-
-		// Nothing? Then return, we'll have something soon.
-		if payload == nil {
+		if reason, kicked := this.findKick(item); kicked {
+			this.session.handleKick(this, reason)
 			return nil
 		}
+		pkt.Payload[i] = rewriter.RewriteClientbound(item)
+		this.session.trackClientbound(pkt.Payload[i])
+	}
 
-		for _, p := range *payload {
-			repackaged := raknet.GenericRakNetPackage{
-				PacketId: p[0],
-				Payload:  p[1:],
-			}
-			if err = this.session.SendPackage(repackaged); err != nil {
-				return err
-			}
+	if !rewriter.changesIds() {
+		// Nothing changed, so save ourselves compressing it again.
+		return this.session.SendPackage(raknet.GenericRakNetPackage{
+			PacketId: pktBytes[0],
+			Payload:  pktBytes[1:],
+		})
+	}
+	return this.session.SendPackage(pkt)
+}
+
+// Checks whether a packet from the server ends the player's connection to
+// it, and if so, why.
+func (this *SessionConnector) findKick(p []byte) (reason string, kicked bool) {
+	switch p[0] {
+	case raknet.ID_DISCONNECT_NOTIFICATION:
+		return "Server closed", true
+	case mcpe.ID_MCPE_DISCONNECT:
+		pkt := new(mcpe.MCPEDisconnect)
+		if err := pkt.Decode(bytes.NewReader(p[1:])); err != nil {
+			log.Printf("Unable to decode disconnect from %s: %s", this.server.Name, err.Error())
+			return "Disconnected", true
 		}
+		return pkt.Message, true
 	}
+	return "", false
+}
 
-	return
+// Returns the login packet to send to the server, with forwarding data if
+// that is turned on.
+func (this *SessionConnector) loginPacket() *mcpe.MCPELogin {
+	config := this.session.proxy.Config()
+	if !config.IPForward {
+		return this.session.loginPkt
+	}
+
+	login := *this.session.loginPkt
+	data := forwarding.Data{
+		Host:     login.ServerAddress,
+		Address:  this.session.endpoint,
+		UUID:     login.ClientUuid,
+		Username: login.Username,
+		Server:   this.server.Name,
+	}
+	if config.ForwardingSecret != "" {
+		login.ServerAddress = data.Sign([]byte(config.ForwardingSecret))
+	} else {
+		login.ServerAddress = data.Encode()
+	}
+	return &login
 }
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"net"
+	"strings"
 	"sync"
 )
 
@@ -77,11 +78,68 @@ func (this *SessionRegistry) GetByUsername(username string) (session *Session) {
 	return this.byUsername[username]
 }
 
+// Like GetByUsername, but ignores case if there is no exact match.
+func (this *SessionRegistry) FindByUsername(username string) *Session {
+	this.RLock()
+	defer this.RUnlock()
+	if session, ok := this.byUsername[username]; ok {
+		return session
+	}
+	for name, session := range this.byUsername {
+		if strings.EqualFold(name, username) {
+			return session
+		}
+	}
+	return nil
+}
+
+// Returns a snapshot of all logged in players.
+func (this *SessionRegistry) Players() (sessions []*Session) {
+	this.RLock()
+	defer this.RUnlock()
+	sessions = make([]*Session, 0, len(this.byUsername))
+	for _, session := range this.byUsername {
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+// Returns a snapshot of all registered sessions.
+func (this *SessionRegistry) All() (sessions []*Session) {
+	this.RLock()
+	defer this.RUnlock()
+	sessions = make([]*Session, 0, len(this.byEndpoint))
+	for _, session := range this.byEndpoint {
+		sessions = append(sessions, session)
+	}
+	return
+}
+
 func (this *SessionRegistry) Clear() {
 	this.byEndpoint = make(map[string]*Session)
 	this.byUsername = make(map[string]*Session)
 }
 
+// Returns the number of sessions, including those that haven't logged in yet.
+func (this *SessionRegistry) Count() int {
+	this.RLock()
+	defer this.RUnlock()
+	return len(this.byEndpoint)
+}
+
+// Returns the number of sessions from addresses in the given network.
+func (this *SessionRegistry) CountInNetwork(network *net.IPNet) (count int) {
+	this.RLock()
+	defer this.RUnlock()
+	for _, session := range this.byEndpoint {
+		if network.Contains(session.endpoint.IP) {
+			count++
+		}
+	}
+	return
+}
+
+// Returns the number of logged in players.
 func (this *SessionRegistry) Len() (val int) {
 	this.RLock()
 	defer this.RUnlock()
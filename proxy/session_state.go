@@ -13,6 +13,18 @@ const (
 	STATE_CONNECTED
 )
 
+func (this sessionState) String() string {
+	switch this {
+	case STATE_IDENTIFY:
+		return "identify"
+	case STATE_CONNECTING:
+		return "connecting"
+	case STATE_CONNECTED:
+		return "connected"
+	}
+	return "unknown"
+}
+
 type sessionConnectorState int
 
 const (
@@ -4,7 +4,6 @@ import (
 	"../packets/raknet"
 	"bytes"
 	"encoding/hex"
-	"fmt"
 	"log"
 	"net"
 )
@@ -12,6 +11,8 @@ import (
 type unknownSessionEntry struct {
 	buf      []byte
 	endpoint *net.UDPAddr
+	// The local address this packet was sent to, if known.
+	local net.IP
 }
 
 type unknownSession struct {
@@ -44,9 +45,9 @@ func (this *unknownSession) Process() {
 			}
 
 			log.Printf("Handling an unconnected ping packet from %s.", endpoint.String())
-			name := fmt.Sprintf("MCPE;Test;38;0.13.0;%d;25000", this.proxy.Registry.Len())
+			name := this.proxy.PongName(item.local)
 			reply := raknet.NewRakNetUnconnectedPong(pkt.PingId, this.proxy.guid, name)
-			if err = raknet.WriteUDP(this.proxy.conn, endpoint, reply); err != nil {
+			if err = this.proxy.sendTo(endpoint, reply); err != nil {
 				log.Printf("Error whilst handling message from %s", endpoint.String(), err)
 				continue
 			}
@@ -59,12 +60,20 @@ func (this *unknownSession) Process() {
 				continue
 			}
 
+			if this.rejectBanned(endpoint) {
+				continue
+			}
+
 			// Go figure. The packet's full size is (almost) the exact MTU.
 			realMtu := len(item.buf) + 32
 
 			log.Printf("Handling the first stage request packet from %s", endpoint.String())
 			reply := raknet.NewRakNetOpenConnectionReply1(this.proxy.guid, 0, int16(realMtu))
-			if err = raknet.WriteUDP(this.proxy.conn, endpoint, reply); err != nil {
+			if this.proxy.Config().Throttle.HandshakeCookie {
+				reply.Secure = 1
+				reply.Cookie = this.proxy.Cookies.Issue(endpoint)
+			}
+			if err = this.proxy.sendTo(endpoint, reply); err != nil {
 				log.Printf("Error whilst handling message from %s", endpoint.String(), err)
 				continue
 			}
@@ -77,6 +86,40 @@ func (this *unknownSession) Process() {
 				continue
 			}
 
+			// Without a valid cookie we can't be sure the client really is at
+			// this address, so we don't even reply.
+			config := this.proxy.Config()
+			if config.Throttle.HandshakeCookie && !this.proxy.Cookies.Verify(endpoint, pkt.Cookie) {
+				continue
+			}
+
+			if this.rejectBanned(endpoint) {
+				continue
+			}
+
+			// Turn the client away if we're full. Some slots are kept free for
+			// players who may join a full proxy; we only know who they are once
+			// they've logged in.
+			if this.proxy.Registry.Count() >= config.MaxPlayers+config.BypassSlots {
+				log.Printf("Turning away %s, the proxy is full.", endpoint.String())
+				reply := raknet.RakNetNoFreeIncomingConnections{GUID: this.proxy.guid}
+				if err = this.proxy.sendTo(endpoint, reply); err != nil {
+					log.Printf("Error whilst handling message from %s: %s", endpoint.String(), err.Error())
+				}
+				continue
+			}
+
+			if !this.proxy.Throttle.Allow(this.proxy, endpoint.IP) {
+				log.Printf("Turning away %s, it is connecting too often.", endpoint.String())
+				if config.Throttle.Reject == THROTTLE_REJECT_REPLY {
+					reply := raknet.RakNetNoFreeIncomingConnections{GUID: this.proxy.guid}
+					if err = this.proxy.sendTo(endpoint, reply); err != nil {
+						log.Printf("Error whilst handling message from %s: %s", endpoint.String(), err.Error())
+					}
+				}
+				continue
+			}
+
 			// Create the connection for this client.
 			connection := NewSession(this.proxy, pkt.MTU, endpoint)
 			if ok := this.proxy.Registry.Register(connection); !ok {
@@ -90,7 +133,7 @@ func (this *unknownSession) Process() {
 
 			// Send response. Welcome to the club!
 			reply := raknet.NewRakNetOpenConnectionReply2(this.proxy.guid, *endpoint, pkt.MTU)
-			if err = raknet.WriteUDP(this.proxy.conn, endpoint, reply); err != nil {
+			if err = this.proxy.sendTo(endpoint, reply); err != nil {
 				log.Printf("Error whilst handling message from %s", endpoint.String(), err)
 				continue
 			}
@@ -103,3 +146,18 @@ func (this *unknownSession) Process() {
 		continue
 	}
 }
+
+// Replies with ID_CONNECTION_BANNED if the client's address is banned.
+func (this *unknownSession) rejectBanned(endpoint *net.UDPAddr) bool {
+	ban := this.proxy.Bans.CheckAddress(endpoint.IP)
+	if ban == nil {
+		return false
+	}
+
+	log.Printf("Turning away %s, it is banned (%s).", endpoint.String(), ban.Target)
+	reply := raknet.RakNetConnectionBanned{GUID: this.proxy.guid}
+	if err := this.proxy.sendTo(endpoint, reply); err != nil {
+		log.Printf("Error whilst handling message from %s: %s", endpoint.String(), err.Error())
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Players allowed to join while the whitelist is on, persisted to a JSON file.
+type Whitelist struct {
+	sync.RWMutex
+	path string
+	data whitelistData
+}
+
+type whitelistData struct {
+	Enabled bool     `json:"enabled"`
+	Names   []string `json:"names"`
+	UUIDs   []string `json:"uuids"`
+}
+
+// Loads the whitelist from a file. A missing file is an empty whitelist that
+// is turned off.
+func LoadWhitelist(path string) (this *Whitelist, err error) {
+	this = new(Whitelist)
+	this.path = path
+	if err = this.Reload(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Re-reads the whitelist from its file.
+func (this *Whitelist) Reload() error {
+	var data whitelistData
+	raw, err := ioutil.ReadFile(this.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(raw, &data); err != nil {
+			return fmt.Errorf("Unable to parse %s: %s", this.path, err.Error())
+		}
+	}
+
+	this.Lock()
+	this.data = data
+	this.Unlock()
+	return nil
+}
+
+// Must be called with the lock held.
+func (this *Whitelist) save() error {
+	return writeJSONFile(this.path, this.data)
+}
+
+func (this *Whitelist) IsEnabled() bool {
+	this.RLock()
+	defer this.RUnlock()
+	return this.data.Enabled
+}
+
+func (this *Whitelist) SetEnabled(enabled bool) error {
+	this.Lock()
+	defer this.Unlock()
+	this.data.Enabled = enabled
+	return this.save()
+}
+
+// Splits a target into a normalized UUID or username.
+func whitelistTarget(target string) (name string, id string) {
+	if parsed := uuid.Parse(target); parsed != nil {
+		return "", parsed.String()
+	}
+	return strings.ToLower(target), ""
+}
+
+// Adds a username or UUID. Returns whether it wasn't already there.
+func (this *Whitelist) Add(target string) (bool, error) {
+	name, id := whitelistTarget(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if name != "" {
+		if containsString(this.data.Names, name) {
+			return false, nil
+		}
+		this.data.Names = append(this.data.Names, name)
+	} else {
+		if containsString(this.data.UUIDs, id) {
+			return false, nil
+		}
+		this.data.UUIDs = append(this.data.UUIDs, id)
+	}
+	return true, this.save()
+}
+
+// Removes a username or UUID. Returns whether it was there.
+func (this *Whitelist) Remove(target string) (bool, error) {
+	name, id := whitelistTarget(target)
+
+	this.Lock()
+	defer this.Unlock()
+
+	var removed bool
+	if name != "" {
+		this.data.Names, removed = removeString(this.data.Names, name)
+	} else {
+		this.data.UUIDs, removed = removeString(this.data.UUIDs, id)
+	}
+	if !removed {
+		return false, nil
+	}
+	return true, this.save()
+}
+
+// Returns the whitelisted usernames and UUIDs.
+func (this *Whitelist) List() (names []string, uuids []string) {
+	this.RLock()
+	defer this.RUnlock()
+	names = append(names, this.data.Names...)
+	uuids = append(uuids, this.data.UUIDs...)
+	return
+}
+
+// Whether a player may join. Everyone may join while the whitelist is off.
+func (this *Whitelist) Allows(username string, id uuid.UUID) bool {
+	this.RLock()
+	defer this.RUnlock()
+	if !this.data.Enabled {
+		return true
+	}
+	if containsString(this.data.Names, strings.ToLower(username)) {
+		return true
+	}
+	return id != nil && containsString(this.data.UUIDs, id.String())
+}
+
+func removeString(list []string, s string) ([]string, bool) {
+	for i, item := range list {
+		if item == s {
+			return append(list[:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
@@ -0,0 +1,143 @@
+// Floods a proxy running in this process with second handshake requests from
+// addresses that never read our replies, like a spoofed-source flood would,
+// and reports how much state the proxy allocated for them.
+//
+//	go run tools/floodtest/main.go -packets 20000
+//	go run tools/floodtest/main.go -packets 20000 -cookie
+package main
+
+import (
+	"../../packets/raknet"
+	"../../proxy"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const config = `listen = "%s"
+max_players = 1000000
+ban_file = "%s"
+whitelist_file = "%s"
+permissions_file = "%s"
+default_server = "lobby"
+priorities = []
+
+[throttle]
+join_interval = "0s"
+max_per_ip = 0
+handshake_cookie = %t
+
+[health_check]
+enabled = false
+
+[servers.lobby]
+address = "127.0.0.1:1"
+`
+
+type snapshot struct {
+	goroutines int
+	heap       uint64
+	sessions   int
+}
+
+func takeSnapshot(p *proxy.Proxy) snapshot {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return snapshot{runtime.NumGoroutine(), mem.HeapAlloc, p.Registry.Count()}
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:19199", "address the proxy under test listens on")
+	packets := flag.Int("packets", 10000, "number of spoofed requests to send")
+	cookie := flag.Bool("cookie", false, "turn on handshake cookies")
+	flag.Parse()
+
+	dir, err := ioutil.TempDir("", "floodtest")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	contents := fmt.Sprintf(config, *listen, filepath.Join(dir, "bans.json"), filepath.Join(dir, "whitelist.json"), filepath.Join(dir, "permissions.json"), *cookie)
+	if err = ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	c, err := proxy.LoadConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := proxy.NewProxy(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The proxy logs every packet, which would drown out the results.
+	log.SetOutput(ioutil.Discard)
+	go p.ListenAndServe()
+	time.Sleep(500 * time.Millisecond)
+
+	target, err := net.ResolveUDPAddr("udp4", *listen)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	before := takeSnapshot(p)
+
+	// Every request comes from a new socket, so from a new address as far as
+	// the proxy is concerned. The socket is closed straight away, so it never
+	// sees a reply. The cookie is a guess, as an attacker's would be.
+	for i := 1; i <= *packets; i++ {
+		source := &net.UDPAddr{IP: net.IPv4(127, byte(i>>16), byte(i>>8), byte(i))}
+		conn, err := net.DialUDP("udp4", source, target)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		out := new(bytes.Buffer)
+		pkt := raknet.RakNetOpenConnectionRequest2{
+			Secure:         true,
+			Cookie:         rand.Uint32() | 0x80000000,
+			ClientEndpoint: target,
+			MTU:            1400,
+			GUID:           rand.Int63(),
+		}
+		if err = pkt.Encode(out); err == nil {
+			_, err = conn.Write(out.Bytes())
+		}
+		conn.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Don't overflow the socket buffers, or we'd only be measuring the
+		// kernel dropping packets.
+		if i%100 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Give the proxy a moment to work through its queue.
+	time.Sleep(2 * time.Second)
+	after := takeSnapshot(p)
+
+	fmt.Printf("Handshake cookies: %t\n", *cookie)
+	fmt.Printf("Spoofed requests:  %d\n", *packets)
+	fmt.Printf("Goroutines:        %d -> %d\n", before.goroutines, after.goroutines)
+	fmt.Printf("Heap in use:       %d KiB -> %d KiB\n", before.heap/1024, after.heap/1024)
+	fmt.Printf("Sessions:          %d -> %d\n", before.sessions, after.sessions)
+	fmt.Printf("Rejected cookies:  %d\n", p.Cookies.Rejected())
+}